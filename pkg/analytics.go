@@ -18,5 +18,11 @@ type GoogleAnalytics interface {
 	GetRealtimeDimensions(context.Context, *setting.DatasourceSecretSettings, string) ([]model.MetadataItem, error)
 	GetRealTimeMetrics(context.Context, *setting.DatasourceSecretSettings, string) ([]model.MetadataItem, error)
 	GetMetrics(context.Context, *setting.DatasourceSecretSettings, string) ([]model.MetadataItem, error)
+	GetVariableValues(context.Context, *setting.DatasourceSecretSettings, model.VariableQuery) ([]model.MetricFindValue, error)
+	GetDimensionValueSuggestions(context.Context, *setting.DatasourceSecretSettings, string, string, string, string, int64, int64) ([]string, error)
+	CheckCompatibility(context.Context, *setting.DatasourceSecretSettings, model.QueryModel) (*model.Compatibility, error)
 	CheckHealth(context.Context, *setting.DatasourceSecretSettings) (*backend.CheckHealthResult, error)
+	RunRealtimeStream(context.Context, *setting.DatasourceSecretSettings, backend.DataQuery, *backend.StreamSender) error
+	CacheStats() model.CacheStats
+	PurgeCache(string, string) (int, error)
 }
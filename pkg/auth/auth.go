@@ -3,6 +3,8 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,6 +26,21 @@ type Resolved struct {
 	TokenURI    string
 	PrivateKey  []byte
 	Project     string
+	// KeyID is the private_key_id of a service account JSON, empty otherwise
+	KeyID string
+}
+
+// Fingerprint identifies the credentials without exposing them, for use in
+// cache keys: a hash of the client email and key ID, or of the private key
+// itself when the key ID is unknown.
+func (r *Resolved) Fingerprint() string {
+	keyID := r.KeyID
+	if keyID == "" {
+		sum := sha256.Sum256(r.PrivateKey)
+		keyID = hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256([]byte(r.Type + "\x00" + r.ClientEmail + "\x00" + keyID))
+	return hex.EncodeToString(sum[:16])
 }
 
 // Resolve normalises plugin settings into a Resolved descriptor. Explicit
@@ -66,10 +83,11 @@ func resolveJWT(s *setting.DatasourceSecretSettings) (*Resolved, error) {
 		return nil, errors.New("auth: no credentials configured (set privateKey or upload a JWT JSON)")
 	}
 	var blob struct {
-		ClientEmail string `json:"client_email"`
-		PrivateKey  string `json:"private_key"`
-		TokenURI    string `json:"token_uri"`
-		ProjectID   string `json:"project_id"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+		ProjectID    string `json:"project_id"`
 	}
 	if err := json.Unmarshal([]byte(s.JWT), &blob); err != nil {
 		return nil, fmt.Errorf("auth: parsing legacy JWT JSON: %w", err)
@@ -83,5 +101,6 @@ func resolveJWT(s *setting.DatasourceSecretSettings) (*Resolved, error) {
 		TokenURI:    blob.TokenURI,
 		PrivateKey:  []byte(blob.PrivateKey),
 		Project:     blob.ProjectID,
+		KeyID:       blob.PrivateKeyID,
 	}, nil
 }
@@ -133,3 +133,38 @@ func TestResolve_GCEAndWIFAreNotYetImplemented(t *testing.T) {
 		}
 	}
 }
+
+func TestResolved_Fingerprint(t *testing.T) {
+	legacy, err := Resolve(&setting.DatasourceSecretSettings{JWT: sampleJWTJSON})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if legacy.KeyID != "kid" {
+		t.Errorf("KeyID = %q, want kid", legacy.KeyID)
+	}
+	fingerprint := legacy.Fingerprint()
+	if fingerprint == "" || strings.Contains(fingerprint, "demo") {
+		t.Errorf("fingerprint must be a non-empty hash, got %q", fingerprint)
+	}
+
+	explicit := func(email, key string) string {
+		r, err := Resolve(&setting.DatasourceSecretSettings{
+			ClientEmail: email,
+			TokenURI:    "https://oauth2.googleapis.com/token",
+			PrivateKey:  key,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return r.Fingerprint()
+	}
+	if explicit("a@demo.iam.gserviceaccount.com", "PEM1") != explicit("a@demo.iam.gserviceaccount.com", "PEM1") {
+		t.Errorf("fingerprint must be stable")
+	}
+	if explicit("a@demo.iam.gserviceaccount.com", "PEM1") == explicit("a@demo.iam.gserviceaccount.com", "PEM2") {
+		t.Errorf("different keys without a key ID must not collide")
+	}
+	if explicit("a@demo.iam.gserviceaccount.com", "PEM1") == explicit("b@demo.iam.gserviceaccount.com", "PEM1") {
+		t.Errorf("different client emails must not collide")
+	}
+}
@@ -12,13 +12,14 @@ import (
 // NewHTTPClient returns an HTTP client whose transport injects an OAuth2
 // access token derived from the resolved auth descriptor. Pass the result
 // to `option.WithHTTPClient(...)` when constructing a google-api service.
-func NewHTTPClient(ctx context.Context, r *Resolved, scopes []string) (*http.Client, error) {
+// middlewares run after the token is set, once per request sent to Google.
+func NewHTTPClient(ctx context.Context, r *Resolved, scopes []string, middlewares ...httpclient.Middleware) (*http.Client, error) {
 	provider, err := newTokenProvider(r, scopes)
 	if err != nil {
 		return nil, err
 	}
 	opts := httpclient.Options{
-		Middlewares: []httpclient.Middleware{tokenprovider.AuthMiddleware(provider)},
+		Middlewares: append([]httpclient.Middleware{tokenprovider.AuthMiddleware(provider)}, middlewares...),
 	}
 	return httpclient.New(opts)
 }
@@ -40,3 +41,14 @@ func newTokenProvider(r *Resolved, scopes []string) (tokenprovider.TokenProvider
 	}
 	return nil, fmt.Errorf("auth: token provider for %q not implemented", r.Type)
 }
+
+// FetchToken mints an access token for the resolved credentials. It tells a
+// bad key or token URI apart from an API that rejects a valid token.
+func FetchToken(ctx context.Context, r *Resolved, scopes []string) error {
+	provider, err := newTokenProvider(r, scopes)
+	if err != nil {
+		return err
+	}
+	_, err = provider.GetAccessToken(ctx)
+	return err
+}
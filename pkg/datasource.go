@@ -3,33 +3,47 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/gav4"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
-	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GoogleAnalyticsDataSource handler for google sheets
 type GoogleAnalyticsDataSource struct {
 	analytics       GoogleAnalytics
 	resourceHandler backend.CallResourceHandler
+	streams         *cache.Cache
+	cache           gav4.Cache
 }
 
 // NewDataSource creates the google analytics datasource and sets up all the routes
 func NewDataSource(_ context.Context, dis backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	cache := cache.New(300*time.Second, 5*time.Second)
+	streams := cache.New(time.Hour, 10*time.Minute)
+	cache := newCache(dis)
 	mux := http.NewServeMux()
 
 	ds := &GoogleAnalyticsDataSource{
-		analytics:       &gav4.GoogleAnalytics{Cache: cache},
+		analytics:       &gav4.GoogleAnalytics{Cache: gav4.WithStats(cache)},
 		resourceHandler: httpadapter.New(mux),
+		streams:         streams,
+		cache:           cache,
 	}
 	mux.HandleFunc("/profile/timezone", ds.handleResourceProfileTimezone)
 	mux.HandleFunc("/dimensions", ds.handleResourceDimensions)
@@ -38,10 +52,58 @@ func NewDataSource(_ context.Context, dis backend.DataSourceInstanceSettings) (i
 	mux.HandleFunc("/property/service-level", ds.handleResourcePropertyServiceLevel)
 	mux.HandleFunc("/realtime-dimensions", ds.handleResourceRealtimeDimensions)
 	mux.HandleFunc("/realtime-metrics", ds.handleResourceRealtimeMetrics)
+	mux.HandleFunc("/variable-query", ds.handleResourceVariableQuery)
+	mux.HandleFunc("/dimension-values", ds.handleResourceDimensionValues)
+	mux.HandleFunc("/compatibility", ds.handleResourceCompatibility)
+	mux.HandleFunc("/cache/stats", ds.handleResourceCacheStats)
+	mux.HandleFunc("/cache/purge", ds.handleResourceCachePurge)
 
 	return ds, nil
 }
 
+// newCache returns the go-cache memory cache, or the on-disk cache when the
+// datasource enables it. A disk cache that cannot be opened falls back to memory.
+func newCache(dis backend.DataSourceInstanceSettings) gav4.Cache {
+	memory := gav4.NewMemoryCache(300*time.Second, 5*time.Second)
+	config, err := setting.LoadSettings(backend.PluginContext{DataSourceInstanceSettings: &dis})
+	if err != nil || !config.PersistentCache {
+		return memory
+	}
+
+	dir := config.PersistentCacheDirectory
+	if dir == "" {
+		if dir, err = os.UserCacheDir(); err != nil {
+			dir = os.TempDir()
+		}
+		dir = filepath.Join(dir, "grafana-google-analytics-datasource")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.DefaultLogger.Error("newCache: Fail create cache directory, using memory cache", "error", err.Error())
+		return memory
+	}
+	maxSizeMB := config.PersistentCacheMaxSizeMB
+	if maxSizeMB <= 0 {
+		maxSizeMB = gav4.GaDiskCacheDefaultMaxSizeMB
+	}
+	disk, err := gav4.NewDiskCache(filepath.Join(dir, dis.UID+".db"), 300*time.Second, maxSizeMB<<20)
+	if err != nil {
+		log.DefaultLogger.Error("newCache: Fail open disk cache, using memory cache", "error", err.Error())
+		return memory
+	}
+	return disk
+}
+
+// Dispose releases the disk cache of an instance Grafana replaced after a
+// settings change. The new instance already shares the open file, which is
+// closed with the last instance using it.
+func (ds *GoogleAnalyticsDataSource) Dispose() {
+	if closer, ok := ds.cache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.DefaultLogger.Error("Dispose: Fail close cache", "error", err.Error())
+		}
+	}
+}
+
 func (ds *GoogleAnalyticsDataSource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	return ds.resourceHandler.CallResource(ctx, req, sender)
 }
@@ -61,20 +123,30 @@ func (ds *GoogleAnalyticsDataSource) CheckHealth(ctx context.Context, req *backe
 }
 
 // QueryData queries for data.
+// Every query runs in a child span of the QueryData span.
 func (ds *GoogleAnalyticsDataSource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GoogleAnalyticsDataSource.QueryData", trace.WithAttributes(
+		attribute.Int("ga.queries", len(req.Queries)),
+	))
+	defer span.End()
+
 	res := backend.NewQueryDataResponse()
 	config, err := setting.LoadSettings(req.PluginContext)
 	if err != nil {
-		return nil, err
+		return nil, tracing.Error(span, err)
 	}
 
 	for _, query := range req.Queries {
 		frames, err := ds.analytics.Query(ctx, config, query)
 		if err != nil {
 			log.DefaultLogger.Error("Fail query", "error", err)
-			res.Responses[query.RefID] = backend.DataResponse{Frames: data.Frames{}, Error: err}
+			span.RecordError(err, trace.WithAttributes(attribute.String("ga.ref_id", query.RefID)))
+			res.Responses[query.RefID] = gav4.ErrorResponse(err)
 			continue
 		}
+		if channel, ok := ds.registerRealtimeStream(req.PluginContext, query); ok {
+			setFramesChannel(*frames, channel)
+		}
 		res.Responses[query.RefID] = backend.DataResponse{Frames: *frames, Error: err}
 	}
 
@@ -219,3 +291,162 @@ func (ds *GoogleAnalyticsDataSource) handleResourceAccountSummaries(rw http.Resp
 	res, err := ds.analytics.GetAccountSummaries(ctx, config)
 	writeResult(rw, "accountSummaries", res, err)
 }
+
+func (ds *GoogleAnalyticsDataSource) handleResourceVariableQuery(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		return
+	}
+
+	ctx := req.Context()
+	config, err := setting.LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeResult(rw, "?", nil, err)
+		return
+	}
+	query := req.URL.Query()
+	variableQuery := model.VariableQuery{
+		Type:          model.VariableQueryType(query.Get("type")),
+		AccountID:     query.Get("accountId"),
+		WebPropertyID: query.Get("webPropertyId"),
+		Dimension:     query.Get("dimension"),
+		StartDate:     query.Get("startDate"),
+		EndDate:       query.Get("endDate"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		variableQuery.Limit, err = strconv.ParseInt(limit, 10, 64)
+		if err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+	}
+	if filter := query.Get("filter"); filter != "" {
+		if err := json.Unmarshal([]byte(filter), &variableQuery.Filter); err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid filter: %w", err))
+			return
+		}
+	}
+
+	res, err := ds.analytics.GetVariableValues(ctx, config, variableQuery)
+	writeResult(rw, "values", res, err)
+}
+
+func (ds *GoogleAnalyticsDataSource) handleResourceDimensionValues(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		return
+	}
+
+	ctx := req.Context()
+	config, err := setting.LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeResult(rw, "?", nil, err)
+		return
+	}
+	query := req.URL.Query()
+	var (
+		webPropertyId = query.Get("webPropertyId")
+		dimension     = query.Get("dimension")
+		prefix        = query.Get("prefix")
+		matchType     = query.Get("matchType")
+		days, limit   int64
+	)
+	if v := query.Get("days"); v != "" {
+		if days, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid days: %w", err))
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.ParseInt(v, 10, 64); err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid limit: %w", err))
+			return
+		}
+	}
+
+	res, err := ds.analytics.GetDimensionValueSuggestions(ctx, config, webPropertyId, dimension, prefix, matchType, days, limit)
+	writeResult(rw, "values", res, err)
+}
+
+func (ds *GoogleAnalyticsDataSource) handleResourceCompatibility(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		return
+	}
+
+	ctx := req.Context()
+	config, err := setting.LoadSettings(httpadapter.PluginConfigFromContext(ctx))
+	if err != nil {
+		writeResult(rw, "?", nil, err)
+		return
+	}
+	query := req.URL.Query()
+	queryModel := model.QueryModel{
+		WebPropertyID: query.Get("webPropertyId"),
+		Metrics:       splitList(query.Get("metrics")),
+		Dimensions:    splitList(query.Get("dimensions")),
+	}
+	if filter := query.Get("dimensionFilter"); filter != "" {
+		if err := json.Unmarshal([]byte(filter), &queryModel.DimensionFilter); err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid dimensionFilter: %w", err))
+			return
+		}
+	}
+	if filter := query.Get("metricFilter"); filter != "" {
+		if err := json.Unmarshal([]byte(filter), &queryModel.MetricFilter); err != nil {
+			writeResult(rw, "?", nil, fmt.Errorf("invalid metricFilter: %w", err))
+			return
+		}
+	}
+
+	res, err := ds.analytics.CheckCompatibility(ctx, config, queryModel)
+	writeResult(rw, "compatibility", res, err)
+}
+
+// splitList splits a comma separated query parameter, dropping empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// requireEditor rejects cache administration requests of viewers and
+// anonymous users with 403.
+func requireEditor(rw http.ResponseWriter, req *http.Request) bool {
+	user := httpadapter.PluginConfigFromContext(req.Context()).User
+	if user != nil && (user.Role == "Admin" || user.Role == "Editor") {
+		return true
+	}
+	body, _ := json.Marshal(map[string]string{"error": "cache administration requires the Admin or Editor role"})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusForbidden)
+	_, _ = rw.Write(body)
+	return false
+}
+
+func (ds *GoogleAnalyticsDataSource) handleResourceCacheStats(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet || !requireEditor(rw, req) {
+		return
+	}
+	writeResult(rw, "stats", ds.analytics.CacheStats(), nil)
+}
+
+// handleResourceCachePurge deletes cached entries, optionally limited to a
+// namespace (metadata, accountSummaries, properties, reports, variables) and
+// a property.
+func (ds *GoogleAnalyticsDataSource) handleResourceCachePurge(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || !requireEditor(rw, req) {
+		return
+	}
+	query := req.URL.Query()
+	var (
+		namespace     = query.Get("namespace")
+		webPropertyId = query.Get("webPropertyId")
+	)
+	res, err := ds.analytics.PurgeCache(namespace, webPropertyId)
+	if err == nil {
+		log.DefaultLogger.Info("Cache purged", "namespace", namespace, "webPropertyId", webPropertyId, "entries", res)
+	}
+	writeResult(rw, "purged", res, err)
+}
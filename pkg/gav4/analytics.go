@@ -12,49 +12,104 @@ import (
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
-	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // GoogleAnalyticsv4DataSource handler
 type GoogleAnalytics struct {
-	Cache *cache.Cache
+	Cache Cache
+	// newClient creates the Google API client of a request, NewGoogleClient
+	// unless a test points the plugin at a fake GA server
+	newClient func(ctx context.Context, config *setting.DatasourceSecretSettings) (*GoogleClient, error)
 }
 
+func (ga *GoogleAnalytics) googleClient(ctx context.Context, config *setting.DatasourceSecretSettings) (*GoogleClient, error) {
+	if ga.newClient != nil {
+		return ga.newClient(ctx, config)
+	}
+	return NewGoogleClient(ctx, config)
+}
+
+// Query runs one query of a QueryData request in a span, which the report and
+// transform spans of the query are children of.
 func (ga *GoogleAnalytics) Query(ctx context.Context, config *setting.DatasourceSecretSettings, query backend.DataQuery) (*data.Frames, error) {
-	client, err := NewGoogleClient(ctx, config)
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GoogleAnalytics.Query")
+	defer span.End()
+	frames, err := ga.query(ctx, config, query)
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	return frames, nil
+}
+
+func (ga *GoogleAnalytics) query(ctx context.Context, config *setting.DatasourceSecretSettings, query backend.DataQuery) (*data.Frames, error) {
+	client, err := ga.googleClient(ctx, config)
 	if err != nil {
 		log.DefaultLogger.Error("Query: Fail NewGoogleClient", "error", err.Error())
-		return nil, err
+		return nil, invalidCredentials(err)
 	}
 	queryModel, err := GetQueryModel(query)
 	if err != nil {
 		log.DefaultLogger.Error("Failed to read query: %w", "error", err)
-		return nil, fmt.Errorf("failed to read query: %w", err)
+		return nil, invalidQuery(fmt.Errorf("failed to read query: %w", err))
+	}
+	if err := ga.restrictQueryModel(ctx, config, queryModel); err != nil {
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(queryAttributes(*queryModel)...)
+
+	if queryModel.Mode == model.ANNOTATIONS {
+		return ga.queryAnnotations(ctx, client, queryModel)
 	}
 
 	if len(queryModel.WebPropertyID) == 0 {
 		log.DefaultLogger.Error("Query", "error", "Required WebPropertyID")
-		return nil, fmt.Errorf("required webpropertyid")
+		return nil, invalidQuery(fmt.Errorf("required webpropertyid"))
 	}
 
 	if len(queryModel.Dimensions) == 0 && len(queryModel.Metrics) == 0 {
 		log.DefaultLogger.Error("Query", "error", "Required Dimensions or Metrics")
-		return nil, fmt.Errorf("required dimensions or metrics")
+		return nil, invalidQuery(fmt.Errorf("required dimensions or metrics"))
 	}
 
 	if queryModel.Mode == model.TIME_SERIES && len(queryModel.TimeDimension) == 0 {
 		log.DefaultLogger.Error("Query", "error", "TimeSeries query need TimeDimension")
-		return nil, fmt.Errorf("time series query need time dimensions")
+		return nil, invalidQuery(fmt.Errorf("time series query need time dimensions"))
+	}
+
+	var renamed map[string]string
+	if queryModel.Mode != model.REALTIME {
+		renamed = ga.rewriteDeprecatedFields(ctx, config, queryModel)
+	}
+
+	if len(queryModel.WebPropertyIDs) > 1 {
+		frames, err := ga.queryProperties(ctx, config, client, queryModel)
+		if err != nil {
+			return nil, err
+		}
+		restoreDeprecatedNames(*frames, renamed)
+		return frames, nil
 	}
 
-	report, err := ga.getReport(ctx, client, queryModel)
+	report, cached, err := ga.getCachedReport(ctx, client, queryModel, reportCacheDuration(config, queryModel))
 	if err != nil {
 		log.DefaultLogger.Error("Query", "error", err)
+		return nil, explainBadRequest(client, queryModel, err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attributeCacheHit.Bool(cached))
+	if err := applyCalculatedFields(report, queryModel.CalculatedFields); err != nil {
 		return nil, err
 	}
 
-	return transformReportsResponseToDataFrames(report, queryModel.RefID, queryModel.Timezone, queryModel.Mode, queryModel.From, queryModel.To)
+	frames, err := transformReportsResponseToDataFrames(ctx, report, queryModel.RefID, queryModel.Timezone, queryModel.Mode, queryModel.From, queryModel.To)
+	if err != nil {
+		return nil, err
+	}
+	setCacheMeta(*frames, cached)
+	restoreDeprecatedNames(*frames, renamed)
+	return frames, nil
 
 }
 
@@ -64,7 +119,7 @@ func (ga *GoogleAnalytics) getReport(ctx context.Context, client *GoogleClient,
 	switch queryModel.Mode {
 	case model.REALTIME:
 		log.DefaultLogger.Debug("Query", "realtime")
-		r, err := client.getRealtimeReport(*queryModel)
+		r, err := client.getRealtimeReport(ctx, *queryModel)
 		if err != nil {
 			log.DefaultLogger.Error("Query", "error", err)
 			return nil, err
@@ -78,13 +133,13 @@ func (ga *GoogleAnalytics) getReport(ctx context.Context, client *GoogleClient,
 		report = cvt
 		log.DefaultLogger.Debug("Query", "realtime end")
 	case model.TIME_SERIES, model.TABLE:
-		report, err = client.getReport(*queryModel)
+		report, err = client.getReport(ctx, *queryModel)
 		if err != nil {
 			log.DefaultLogger.Error("Query", "error", err)
 			return nil, err
 		}
 	default:
-    report, err = client.getReport(*queryModel)
+    report, err = client.getReport(ctx, *queryModel)
     log.DefaultLogger.Debug("getReport", "no query.mode use default timeseries")
 		if err != nil {
 			log.DefaultLogger.Error("Query", "error", err)
@@ -95,189 +150,167 @@ func (ga *GoogleAnalytics) getReport(ctx context.Context, client *GoogleClient,
 }
 
 func (ga *GoogleAnalytics) GetTimezone(ctx context.Context, config *setting.DatasourceSecretSettings, accountId string, webPropertyId string, profileId string) (string, error) {
-	client, err := NewGoogleClient(ctx, config)
+	if err := ga.checkProperty(ctx, config, webPropertyId); err != nil {
+		return "", err
+	}
+	client, err := ga.googleClient(ctx, config)
 	if err != nil {
 		return "", fmt.Errorf("failed to create Google API client: %w", err)
 	}
 
-	cacheKey := fmt.Sprintf("analytics:account:%s:webproperty:%s:profile:%s:timezone", accountId, webPropertyId, profileId)
-	if item, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return item.(string), nil
+	cacheKey := newCacheKey(CacheNamespaceProperties, client.identity, webPropertyId, "timezone")
+	if item, found := cacheGet[string](ga.Cache, cacheKey); found {
+		return item, nil
 	}
 
-	webproperty, err := client.GetWebProperty(webPropertyId)
+	webproperty, err := client.GetWebProperty(ctx, webPropertyId)
 	if err != nil {
 		return "", err
 	}
 
 	timezone := webproperty.TimeZone
 
-	ga.Cache.Set(cacheKey, timezone, 60*time.Second)
+	cacheSet(ga.Cache, cacheKey, timezone, 60*time.Second)
 	return timezone, nil
 }
 
 func (ga *GoogleAnalytics) GetServiceLevel(ctx context.Context, config *setting.DatasourceSecretSettings, accountId string, webPropertyId string) (string, error) {
-	client, err := NewGoogleClient(ctx, config)
+	if err := ga.checkProperty(ctx, config, webPropertyId); err != nil {
+		return "", err
+	}
+	client, err := ga.googleClient(ctx, config)
 	if err != nil {
 		return "", fmt.Errorf("failed to create Google API client: %w", err)
 	}
 
-	cacheKey := fmt.Sprintf("analytics:account:%s:webproperty:%s:service_level", accountId, webPropertyId)
-	if item, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return item.(string), nil
+	cacheKey := newCacheKey(CacheNamespaceProperties, client.identity, webPropertyId, "serviceLevel")
+	if item, found := cacheGet[string](ga.Cache, cacheKey); found {
+		return item, nil
 	}
 
-	webproperty, err := client.GetWebProperty(webPropertyId)
+	webproperty, err := client.GetWebProperty(ctx, webPropertyId)
 	if err != nil {
 		return "", err
 	}
 
 	serviceLevel := webproperty.ServiceLevel
 
-	ga.Cache.Set(cacheKey, serviceLevel, 60*time.Second)
+	cacheSet(ga.Cache, cacheKey, serviceLevel, 60*time.Second)
 	return serviceLevel, nil
 }
 
 func (ga *GoogleAnalytics) getFilteredMetadata(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, []model.MetadataItem, error) {
-	client, err := NewGoogleClient(ctx, config)
+	client, err := ga.googleClient(ctx, config)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create Google API client: %w", err)
 	}
-	metadata, err := client.getMetadata(propertyId)
+	metadata, err := client.getMetadata(ctx, propertyId)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get metadata: %w", err)
 	}
-	var dimensions = make([]model.MetadataItem, len(metadata.Dimensions))
-	var metrics = make([]model.MetadataItem, len(metadata.Metrics))
-	for idx, metric := range metadata.Metrics {
-		var metadataItem = &model.MetadataItem{}
-		metadataItem.ID = metric.ApiName
-		metadataItem.Attributes.Description = metric.Description
-		metadataItem.Attributes.Group = metric.Category
-		metadataItem.Attributes.UIName = metric.UiName
-		metrics[idx] = *metadataItem
-	}
-	for idx, dimension := range metadata.Dimensions {
-		var metadataItem = &model.MetadataItem{}
-		metadataItem.ID = dimension.ApiName
-		metadataItem.Attributes.Description = dimension.Description
-		metadataItem.Attributes.Group = dimension.Category
-		metadataItem.Attributes.UIName = dimension.UiName
-		dimensions[idx] = *metadataItem
-	}
+	metrics, dimensions := metadataItems(metadata, client.getCustomDefinitions(ctx, propertyId))
 
 	return metrics, dimensions, nil
 }
 
-func (ga *GoogleAnalytics) GetDimensions(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
-	cacheKey := "ga:metadata:" + propertyId + ":dimensions"
-	if dimensions, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return dimensions.([]model.MetadataItem), nil
+// getMetadataItems returns the metrics and dimensions of a property. Both
+// lists come from one metadata call and are cached together.
+func (ga *GoogleAnalytics) getMetadataItems(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, []model.MetadataItem, error) {
+	if err := ga.checkProperty(ctx, config, propertyId); err != nil {
+		return nil, nil, err
 	}
-	_, dimensions, err := ga.getFilteredMetadata(ctx, config, propertyId)
+	fingerprint, err := credentialFingerprint(config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	return dimensions, nil
-}
-
-func (ga *GoogleAnalytics) GetMetrics(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
-	cacheKey := "ga:metadata:" + propertyId + ":metrics"
-	if metrics, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return metrics.([]model.MetadataItem), nil
+	metricsKey := newCacheKey(CacheNamespaceMetadata, fingerprint, propertyId, "metrics")
+	dimensionsKey := newCacheKey(CacheNamespaceMetadata, fingerprint, propertyId, "dimensions")
+	metrics, metricsFound := cacheGet[[]model.MetadataItem](ga.Cache, metricsKey)
+	dimensions, dimensionsFound := cacheGet[[]model.MetadataItem](ga.Cache, dimensionsKey)
+	if metricsFound && dimensionsFound {
+		return metrics, dimensions, nil
 	}
-	metrics, _, err := ga.getFilteredMetadata(ctx, config, propertyId)
+	metrics, dimensions, err = ga.getFilteredMetadata(ctx, config, propertyId)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	ga.Cache.Set(cacheKey, metrics, time.Hour)
+	cacheSet(ga.Cache, metricsKey, metrics, time.Hour)
+	cacheSet(ga.Cache, dimensionsKey, dimensions, time.Hour)
 
-	return metrics, nil
+	return metrics, dimensions, nil
 }
 
-func (ga *GoogleAnalytics) GetRealtimeDimensions(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
-	cacheKey := "ga:metadata:" + propertyId + ":realtime-dimensions"
-	if dimensions, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return dimensions.([]model.MetadataItem), nil
-	}
+func (ga *GoogleAnalytics) GetDimensions(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
+	_, dimensions, err := ga.getMetadataItems(ctx, config, propertyId)
+	return dimensions, err
+}
 
-	return GaRealTimeDimensions, nil
+func (ga *GoogleAnalytics) GetMetrics(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
+	metrics, _, err := ga.getMetadataItems(ctx, config, propertyId)
+	return metrics, err
 }
 
-func (ga *GoogleAnalytics) GetRealTimeMetrics(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
-	cacheKey := "ga:metadata:" + propertyId + ":realtime-metrics"
-	if metrics, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return metrics.([]model.MetadataItem), nil
+func (ga *GoogleAnalytics) GetRealtimeDimensions(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
+	if err := ga.checkProperty(ctx, config, propertyId); err != nil {
+		return nil, err
+	}
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := newCacheKey(CacheNamespaceMetadata, fingerprint, propertyId, "realtime-dimensions")
+	if dimensions, found := cacheGet[[]model.MetadataItem](ga.Cache, cacheKey); found {
+		return dimensions, nil
+	}
+	catalogue, err := ga.GetDimensions(ctx, config, propertyId)
+	if err != nil {
+		log.DefaultLogger.Warn("GetRealtimeDimensions: falling back to the static list", "error", err.Error())
+		return GaRealTimeDimensions, nil
 	}
+	dimensions := realtimeItems(GaRealTimeDimensions, catalogue, true)
 
-	return GaRealTimeMetrics, nil
-}
+	cacheSet(ga.Cache, cacheKey, dimensions, time.Hour)
 
-func (ga *GoogleAnalytics) CheckHealth(ctx context.Context, config *setting.DatasourceSecretSettings) (*backend.CheckHealthResult, error) {
-	var status = backend.HealthStatusOk
-	var message = "Success"
+	return dimensions, nil
+}
 
-	client, err := NewGoogleClient(ctx, config)
-	if err != nil {
-		log.DefaultLogger.Error("CheckHealth: Fail NewGoogleClient", "error", err)
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "CheckHealth: Fail NewGoogleClient" + err.Error(),
-		}, nil
+func (ga *GoogleAnalytics) GetRealTimeMetrics(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) ([]model.MetadataItem, error) {
+	if err := ga.checkProperty(ctx, config, propertyId); err != nil {
+		return nil, err
 	}
-
-	accountSummaries, err := ga.GetAccountSummaries(ctx, config)
+	fingerprint, err := credentialFingerprint(config)
 	if err != nil {
-		log.DefaultLogger.Error("CheckHealth: Fail getPropetyList", "error", err.Error())
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "CheckHealth: Fail getPropetyList" + err.Error(),
-		}, nil
+		return nil, err
 	}
-	if len(accountSummaries) == 0 {
-		log.DefaultLogger.Error("CheckHealth: Not Exist Valid Property")
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "CheckHealth: Not Exist Valid Property",
-		}, nil
+	cacheKey := newCacheKey(CacheNamespaceMetadata, fingerprint, propertyId, "realtime-metrics")
+	if metrics, found := cacheGet[[]model.MetadataItem](ga.Cache, cacheKey); found {
+		return metrics, nil
 	}
-
-	testData := model.QueryModel{AccountID: accountSummaries[0].Account, WebPropertyID: accountSummaries[0].PropertySummaries[0].Property, ProfileID: "", StartDate: "yesterday", EndDate: "today", RefID: "a", Metrics: []string{"active1DayUsers"}, TimeDimension: "date", Dimensions: []string{"date"}, PageSize: GaReportMaxResult, PageToken: "", UseNextPage: false, Timezone: "UTC", FiltersExpression: "", Offset: 0}
-	res, err := client.getReport(testData)
-
+	catalogue, err := ga.GetMetrics(ctx, config, propertyId)
 	if err != nil {
-		log.DefaultLogger.Error("CheckHealth: GET request to analyticsdata beta returned error", "error", err.Error())
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: "CheckHealth: Test Request Fail" + err.Error(),
-		}, nil
+		log.DefaultLogger.Warn("GetRealTimeMetrics: falling back to the static list", "error", err.Error())
+		return GaRealTimeMetrics, nil
 	}
+	metrics := realtimeItems(GaRealTimeMetrics, catalogue, false)
 
-	if res != nil {
-		log.DefaultLogger.Debug("HTTPStatusCode", "status", res.HTTPStatusCode)
-		log.DefaultLogger.Debug("res", res)
-	}
+	cacheSet(ga.Cache, cacheKey, metrics, time.Hour)
 
-	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
-	}, nil
+	return metrics, nil
 }
 
 func (ga *GoogleAnalytics) GetAccountSummaries(ctx context.Context, config *setting.DatasourceSecretSettings) ([]*model.AccountSummary, error) {
-	client, err := NewGoogleClient(ctx, config)
+	client, err := ga.googleClient(ctx, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Google API client: %w", err)
 	}
 
-	cacheKey := fmt.Sprintf("analytics:accountsummaries:%s", config.JWT)
-	if item, _, found := ga.Cache.GetWithExpiration(cacheKey); found {
-		return item.([]*model.AccountSummary), nil
+	cacheKey := newCacheKey(CacheNamespaceAccountSummaries, client.identity, "")
+	if item, found := cacheGet[[]*model.AccountSummary](ga.Cache, cacheKey); found {
+		return filterAccountSummaries(config, item), nil
 	}
 
-	rawAccountSummaries, err := client.getAccountSummaries("")
+	rawAccountSummaries, err := client.getAccountSummaries(ctx, "")
 	if err != nil {
 		return nil, err
 	}
@@ -307,6 +340,6 @@ func (ga *GoogleAnalytics) GetAccountSummaries(ctx context.Context, config *sett
 		}
 	}
 	log.DefaultLogger.Debug("GA4 GetAccountSummaries parsed accounts", "debug", accounts)
-	ga.Cache.Set(cacheKey, accounts, 60*time.Second)
-	return accounts, nil
+	cacheSet(ga.Cache, cacheKey, accounts, 60*time.Second)
+	return filterAccountSummaries(config, accounts), nil
 }
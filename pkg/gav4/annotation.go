@@ -0,0 +1,267 @@
+package gav4
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// annotation is one row of an annotation frame
+type annotation struct {
+	time  time.Time
+	title string
+	text  string
+	tags  string
+	actor string
+}
+
+// annotationsToFrame builds the frame layout Grafana reads annotations from.
+func annotationsToFrame(refId string, annotations []annotation) *data.Frame {
+	var (
+		times  = make([]time.Time, len(annotations))
+		titles = make([]string, len(annotations))
+		texts  = make([]string, len(annotations))
+		tags   = make([]string, len(annotations))
+		actors = make([]string, len(annotations))
+	)
+	for i, a := range annotations {
+		times[i] = a.time
+		titles[i] = a.title
+		texts[i] = a.text
+		tags[i] = a.tags
+		actors[i] = a.actor
+	}
+	frame := data.NewFrame(refId,
+		data.NewField("time", nil, times),
+		data.NewField("title", nil, titles),
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+		data.NewField("actor", nil, actors),
+	)
+	frame.RefID = refId
+	frame.Meta = &data.FrameMeta{DataTopic: data.DataTopicAnnotations}
+	return frame
+}
+
+func (ga *GoogleAnalytics) queryAnnotations(ctx context.Context, client *GoogleClient, queryModel *model.QueryModel) (*data.Frames, error) {
+	switch queryModel.AnnotationType {
+	case model.AnnotationChangeHistory, "":
+		return ga.queryChangeHistoryAnnotations(ctx, client, queryModel)
+	case model.AnnotationEvents:
+		return ga.queryEventAnnotations(ctx, client, queryModel)
+	default:
+		return nil, fmt.Errorf("unknown annotation type %q", queryModel.AnnotationType)
+	}
+}
+
+func (ga *GoogleAnalytics) queryChangeHistoryAnnotations(ctx context.Context, client *GoogleClient, queryModel *model.QueryModel) (*data.Frames, error) {
+	accountId := queryModel.AccountID
+	if accountId == "" {
+		if queryModel.WebPropertyID == "" {
+			return nil, fmt.Errorf("change history annotations need an account or a property")
+		}
+		webproperty, err := client.GetWebProperty(ctx, queryModel.WebPropertyID)
+		if err != nil {
+			return nil, err
+		}
+		accountId = webproperty.Account
+	}
+
+	req := &analyticsadmin.GoogleAnalyticsAdminV1betaSearchChangeHistoryEventsRequest{
+		Property:           queryModel.WebPropertyID,
+		EarliestChangeTime: queryModel.From.UTC().Format(time.RFC3339),
+		LatestChangeTime:   queryModel.To.UTC().Format(time.RFC3339),
+	}
+	events, err := client.searchChangeHistoryEvents(ctx, accountId, req)
+	if err != nil {
+		return nil, err
+	}
+
+	annotations := make([]annotation, 0, len(events))
+	for _, event := range events {
+		a, err := changeHistoryAnnotation(event)
+		if err != nil {
+			log.DefaultLogger.Warn("queryChangeHistoryAnnotations: skipping event", "id", event.Id, "error", err.Error())
+			continue
+		}
+		annotations = append(annotations, a)
+	}
+	return &data.Frames{annotationsToFrame(queryModel.RefID, annotations)}, nil
+}
+
+func changeHistoryAnnotation(event *analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryEvent) (annotation, error) {
+	changeTime, err := time.Parse(time.RFC3339, event.ChangeTime)
+	if err != nil {
+		return annotation{}, err
+	}
+
+	actor := event.UserActorEmail
+	if actor == "" {
+		actor = strings.ToLower(event.ActorType)
+	}
+
+	lines := make([]string, 0, len(event.Changes)+1)
+	tags := []string{"ga4", "change history"}
+	for _, change := range event.Changes {
+		lines = append(lines, strings.ToLower(change.Action)+" "+describeChangedResource(change))
+		tags = append(tags, resourceType(change.Resource))
+	}
+
+	title := fmt.Sprintf("%d changes", len(event.Changes))
+	if len(lines) == 1 {
+		title = lines[0]
+	}
+	if event.ChangesFiltered {
+		lines = append(lines, "(some changes were filtered out)")
+	}
+	lines = append(lines, "by "+actor)
+
+	return annotation{
+		time:  changeTime,
+		title: title,
+		text:  strings.Join(lines, "\n"),
+		tags:  strings.Join(tags, ","),
+		actor: actor,
+	}, nil
+}
+
+// eventAnnotationDimensions are always requested by an events annotation
+// query; the query's own dimensions are added as event parameters.
+var eventAnnotationDimensions = []string{"dateHourMinute", "eventName"}
+
+func (ga *GoogleAnalytics) queryEventAnnotations(ctx context.Context, client *GoogleClient, queryModel *model.QueryModel) (*data.Frames, error) {
+	if queryModel.WebPropertyID == "" {
+		return nil, fmt.Errorf("required webpropertyid")
+	}
+	if len(queryModel.EventNames) == 0 {
+		return nil, fmt.Errorf("event annotations need at least one event name")
+	}
+
+	eventQuery := *queryModel
+	eventQuery.Metrics = []string{"eventCount"}
+	eventQuery.Dimensions = append([]string{}, eventAnnotationDimensions...)
+	for _, dimension := range queryModel.Dimensions {
+		if dimension != queryModel.TimeDimension && !util.Contains(eventAnnotationDimensions, dimension) {
+			eventQuery.Dimensions = append(eventQuery.Dimensions, dimension)
+		}
+	}
+	eventFilter := &analyticsdata.FilterExpression{
+		Filter: &analyticsdata.Filter{
+			FieldName: "eventName",
+			InListFilter: &analyticsdata.InListFilter{
+				Values:        queryModel.EventNames,
+				CaseSensitive: true,
+			},
+		},
+	}
+	if filterHasContent(queryModel.DimensionFilter) {
+		eventFilter = &analyticsdata.FilterExpression{
+			AndGroup: &analyticsdata.FilterExpressionList{
+				Expressions: []*analyticsdata.FilterExpression{eventFilter, queryModel.DimensionFilter},
+			},
+		}
+	}
+	eventQuery.DimensionFilter = eventFilter
+
+	report, err := client.getReport(ctx, eventQuery)
+	if err != nil {
+		return nil, err
+	}
+	tz, err := time.LoadLocation(queryModel.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &data.Frames{annotationsToFrame(queryModel.RefID, eventAnnotations(report, tz, queryModel.From, queryModel.To))}, nil
+}
+
+// eventAnnotations turns every non-zero (minute, event) bucket of the report
+// into an annotation. Parameter dimensions follow the event name in each row.
+func eventAnnotations(report *analyticsdata.RunReportResponse, tz *time.Location, from, to time.Time) []annotation {
+	annotations := make([]annotation, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		if len(row.DimensionValues) < len(eventAnnotationDimensions) || len(row.MetricValues) == 0 {
+			continue
+		}
+		count, err := strconv.ParseInt(row.MetricValues[0].Value, 10, 64)
+		if err != nil || count == 0 {
+			continue
+		}
+		eventTime, err := util.ParseAndTimezoneTime(row.DimensionValues[0].Value, tz)
+		if err != nil {
+			continue
+		}
+		if !from.IsZero() && !to.IsZero() && (eventTime.Before(from) || eventTime.After(to)) {
+			continue
+		}
+
+		eventName := row.DimensionValues[1].Value
+		lines := []string{fmt.Sprintf("%s × %d", eventName, count)}
+		for i, value := range row.DimensionValues[len(eventAnnotationDimensions):] {
+			header := fmt.Sprintf("parameter %d", i)
+			if i+len(eventAnnotationDimensions) < len(report.DimensionHeaders) {
+				header = report.DimensionHeaders[i+len(eventAnnotationDimensions)].Name
+			}
+			lines = append(lines, header+": "+value.Value)
+		}
+		annotations = append(annotations, annotation{
+			time:  *eventTime,
+			title: eventName,
+			text:  strings.Join(lines, "\n"),
+			tags:  "ga4,event," + eventName,
+		})
+	}
+	return annotations
+}
+
+// resourceType returns the collection name of a resource, e.g.
+// "conversionEvents" for properties/1/conversionEvents/2.
+func resourceType(name string) string {
+	segments := strings.Split(name, "/")
+	if len(segments) < 2 {
+		return name
+	}
+	if len(segments)%2 == 1 {
+		// singleton resources such as properties/1/dataRetentionSettings
+		return segments[len(segments)-1]
+	}
+	return segments[len(segments)-2]
+}
+
+func describeChangedResource(change *analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryChange) string {
+	resource := change.ResourceAfterChange
+	if resource == nil {
+		resource = change.ResourceBeforeChange
+	}
+	if resource == nil {
+		return change.Resource
+	}
+	switch {
+	case resource.ConversionEvent != nil:
+		return "conversion event " + resource.ConversionEvent.EventName
+	case resource.DataRetentionSettings != nil:
+		return "data retention " + resource.DataRetentionSettings.EventDataRetention
+	case resource.DataStream != nil:
+		return "data stream " + resource.DataStream.DisplayName
+	case resource.Property != nil:
+		return "property " + resource.Property.DisplayName
+	case resource.Account != nil:
+		return "account " + resource.Account.DisplayName
+	case resource.FirebaseLink != nil:
+		return "firebase link " + resource.FirebaseLink.Project
+	case resource.GoogleAdsLink != nil:
+		return "google ads link " + resource.GoogleAdsLink.CustomerId
+	case resource.MeasurementProtocolSecret != nil:
+		return "measurement protocol secret " + resource.MeasurementProtocolSecret.DisplayName
+	default:
+		return change.Resource
+	}
+}
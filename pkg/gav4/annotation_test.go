@@ -0,0 +1,114 @@
+package gav4
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestChangeHistoryAnnotation(t *testing.T) {
+	event := &analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryEvent{
+		Id:             "1",
+		ChangeTime:     "2024-09-12T10:00:00Z",
+		UserActorEmail: "analyst@example.com",
+		Changes: []*analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryChange{
+			{
+				Action:   "CREATED",
+				Resource: "properties/1/conversionEvents/2",
+				ResourceAfterChange: &analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryChangeChangeHistoryResource{
+					ConversionEvent: &analyticsadmin.GoogleAnalyticsAdminV1betaConversionEvent{EventName: "purchase"},
+				},
+			},
+		},
+	}
+
+	a, err := changeHistoryAnnotation(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !a.time.Equal(time.Date(2024, 9, 12, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("time = %s", a.time)
+	}
+	if a.title != "created conversion event purchase" {
+		t.Errorf("title = %q", a.title)
+	}
+	if a.actor != "analyst@example.com" || !strings.Contains(a.text, "by analyst@example.com") {
+		t.Errorf("actor = %q, text = %q", a.actor, a.text)
+	}
+	if !strings.Contains(a.tags, "conversionEvents") {
+		t.Errorf("tags = %q", a.tags)
+	}
+}
+
+func TestChangeHistoryAnnotation_SystemActorAndManyChanges(t *testing.T) {
+	event := &analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryEvent{
+		ChangeTime: "2024-09-12T10:00:00Z",
+		ActorType:  "SYSTEM",
+		Changes: []*analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryChange{
+			{Action: "UPDATED", Resource: "properties/1/dataRetentionSettings"},
+			{Action: "DELETED", Resource: "properties/1/dataStreams/3"},
+		},
+	}
+
+	a, err := changeHistoryAnnotation(event)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.title != "2 changes" || a.actor != "system" {
+		t.Errorf("title = %q, actor = %q", a.title, a.actor)
+	}
+	if !strings.Contains(a.tags, "dataRetentionSettings") || !strings.Contains(a.tags, "dataStreams") {
+		t.Errorf("tags = %q", a.tags)
+	}
+}
+
+func TestAnnotationsToFrame(t *testing.T) {
+	frame := annotationsToFrame("A", []annotation{{time: time.Now(), title: "t", text: "x"}})
+	if frame.Rows() != 1 || frame.Meta.DataTopic != data.DataTopicAnnotations {
+		t.Errorf("unexpected frame: rows=%d meta=%+v", frame.Rows(), frame.Meta)
+	}
+}
+
+func TestEventAnnotations(t *testing.T) {
+	report := &analyticsdata.RunReportResponse{
+		DimensionHeaders: []*analyticsdata.DimensionHeader{{Name: "dateHourMinute"}, {Name: "eventName"}, {Name: "customEvent:version"}},
+		MetricHeaders:    []*analyticsdata.MetricHeader{{Name: "eventCount", Type: "TYPE_INTEGER"}},
+		Rows: []*analyticsdata.Row{
+			{
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "202409121030"}, {Value: "app_release"}, {Value: "2.1.0"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "3"}},
+			},
+			{
+				// empty bucket from KeepEmptyRows
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "202409121031"}, {Value: "app_release"}, {Value: "2.1.0"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "0"}},
+			},
+			{
+				// outside of the dashboard range
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "202409111030"}, {Value: "deploy"}, {Value: "(not set)"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "1"}},
+			},
+		},
+	}
+	from := time.Date(2024, 9, 12, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 9, 13, 0, 0, 0, 0, time.UTC)
+
+	annotations := eventAnnotations(report, time.UTC, from, to)
+	if len(annotations) != 1 {
+		t.Fatalf("expected 1 annotation, got %d", len(annotations))
+	}
+	a := annotations[0]
+	if !a.time.Equal(time.Date(2024, 9, 12, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("time = %s", a.time)
+	}
+	if a.title != "app_release" {
+		t.Errorf("title = %q", a.title)
+	}
+	if !strings.Contains(a.text, "app_release × 3") || !strings.Contains(a.text, "customEvent:version: 2.1.0") {
+		t.Errorf("text = %q", a.text)
+	}
+}
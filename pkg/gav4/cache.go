@@ -0,0 +1,225 @@
+package gav4
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/patrickmn/go-cache"
+)
+
+// Cache stores encoded responses for GoogleAnalytics. NewMemoryCache is the
+// default; NewDiskCache keeps entries across plugin restarts.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+	// Range calls fn with every live entry until fn returns false
+	Range(fn func(key string, size int) bool)
+}
+
+// Cache namespaces, the first segment of every cache key
+const (
+	CacheNamespaceMetadata         = "metadata"
+	CacheNamespaceAccountSummaries = "accountSummaries"
+	CacheNamespaceProperties       = "properties"
+	CacheNamespaceReports          = "reports"
+	CacheNamespaceVariables        = "variables"
+)
+
+var cacheNamespaces = []string{
+	CacheNamespaceMetadata,
+	CacheNamespaceAccountSummaries,
+	CacheNamespaceProperties,
+	CacheNamespaceReports,
+	CacheNamespaceVariables,
+}
+
+// newCacheKey builds namespace:fingerprint:property:parts..., so that entries
+// can be counted and purged by namespace and property.
+func newCacheKey(namespace string, fingerprint string, property string, parts ...string) string {
+	return strings.Join(append([]string{namespace, fingerprint, normalizeCacheProperty(property)}, parts...), ":")
+}
+
+// parseCacheKey returns the namespace and property of a key built by newCacheKey
+func parseCacheKey(key string) (string, string) {
+	segments := strings.SplitN(key, ":", 4)
+	if len(segments) < 3 {
+		return segments[0], ""
+	}
+	return segments[0], segments[2]
+}
+
+// normalizeCacheProperty keys "123" and "properties/123" alike
+func normalizeCacheProperty(property string) string {
+	return strings.TrimPrefix(property, "properties/")
+}
+
+type memoryCache struct {
+	cache *cache.Cache
+}
+
+// NewMemoryCache returns a Cache backed by go-cache
+func NewMemoryCache(defaultExpiration, cleanupInterval time.Duration) Cache {
+	return &memoryCache{cache: cache.New(defaultExpiration, cleanupInterval)}
+}
+
+func (c *memoryCache) Get(key string) ([]byte, bool) {
+	item, found := c.cache.Get(key)
+	if !found {
+		return nil, false
+	}
+	return item.([]byte), true
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl time.Duration) {
+	c.cache.Set(key, value, ttl)
+}
+
+func (c *memoryCache) Delete(key string) {
+	c.cache.Delete(key)
+}
+
+func (c *memoryCache) Range(fn func(key string, size int) bool) {
+	for key, item := range c.cache.Items() {
+		if !fn(key, len(item.Object.([]byte))) {
+			return
+		}
+	}
+}
+
+// statsCache counts hits and misses per namespace of the Cache it wraps, for
+// CacheStats and for the cache_requests_total metric
+type statsCache struct {
+	Cache
+
+	mu     sync.Mutex
+	hits   map[string]int64
+	misses map[string]int64
+}
+
+// WithStats wraps c so that GoogleAnalytics.CacheStats reports hit ratios
+func WithStats(c Cache) Cache {
+	return &statsCache{Cache: c, hits: map[string]int64{}, misses: map[string]int64{}}
+}
+
+func (c *statsCache) Get(key string) ([]byte, bool) {
+	value, found := c.Cache.Get(key)
+	namespace, _ := parseCacheKey(key)
+	c.mu.Lock()
+	if found {
+		c.hits[namespace]++
+	} else {
+		c.misses[namespace]++
+	}
+	c.mu.Unlock()
+	if found {
+		cacheRequests.WithLabelValues(namespace, "hit").Inc()
+	} else {
+		cacheRequests.WithLabelValues(namespace, "miss").Inc()
+	}
+	return value, found
+}
+
+// CacheStats returns the entries and bytes per namespace, and hits and misses
+// when the cache was wrapped by WithStats.
+func (ga *GoogleAnalytics) CacheStats() model.CacheStats {
+	stats := model.CacheStats{Namespaces: map[string]*model.CacheNamespaceStats{}}
+	for _, namespace := range cacheNamespaces {
+		stats.Namespaces[namespace] = &model.CacheNamespaceStats{}
+	}
+	namespaceStats := func(namespace string) *model.CacheNamespaceStats {
+		if _, ok := stats.Namespaces[namespace]; !ok {
+			stats.Namespaces[namespace] = &model.CacheNamespaceStats{}
+		}
+		return stats.Namespaces[namespace]
+	}
+
+	ga.Cache.Range(func(key string, size int) bool {
+		namespace, _ := parseCacheKey(key)
+		s := namespaceStats(namespace)
+		s.Entries++
+		s.Bytes += int64(size)
+		return true
+	})
+	if counted, ok := ga.Cache.(*statsCache); ok {
+		counted.mu.Lock()
+		for namespace, hits := range counted.hits {
+			namespaceStats(namespace).Hits = hits
+		}
+		for namespace, misses := range counted.misses {
+			namespaceStats(namespace).Misses = misses
+		}
+		counted.mu.Unlock()
+	}
+
+	for _, s := range stats.Namespaces {
+		if s.Hits+s.Misses > 0 {
+			s.HitRatio = float64(s.Hits) / float64(s.Hits+s.Misses)
+		}
+		stats.Total.Entries += s.Entries
+		stats.Total.Bytes += s.Bytes
+		stats.Total.Hits += s.Hits
+		stats.Total.Misses += s.Misses
+	}
+	if stats.Total.Hits+stats.Total.Misses > 0 {
+		stats.Total.HitRatio = float64(stats.Total.Hits) / float64(stats.Total.Hits+stats.Total.Misses)
+	}
+	return stats
+}
+
+// PurgeCache deletes the entries of namespace and property, where empty means
+// any, and returns how many entries were deleted.
+func (ga *GoogleAnalytics) PurgeCache(namespace string, property string) (int, error) {
+	if namespace != "" {
+		known := false
+		for _, n := range cacheNamespaces {
+			known = known || n == namespace
+		}
+		if !known {
+			return 0, fmt.Errorf("unknown cache namespace %q, use one of %s", namespace, strings.Join(cacheNamespaces, ", "))
+		}
+	}
+	property = normalizeCacheProperty(property)
+
+	var keys []string
+	ga.Cache.Range(func(key string, _ int) bool {
+		keyNamespace, keyProperty := parseCacheKey(key)
+		if (namespace == "" || keyNamespace == namespace) && (property == "" || keyProperty == property) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		ga.Cache.Delete(key)
+	}
+	return len(keys), nil
+}
+
+// cacheGet decodes the entry stored under key. An entry that does not decode
+// into T is treated as missing.
+func cacheGet[T any](c Cache, key string) (T, bool) {
+	var value T
+	b, found := c.Get(key)
+	if !found {
+		return value, false
+	}
+	if err := json.Unmarshal(b, &value); err != nil {
+		log.DefaultLogger.Warn("cacheGet: unreadable entry", "key", key, "error", err.Error())
+		return value, false
+	}
+	return value, true
+}
+
+func cacheSet(c Cache, key string, value interface{}, ttl time.Duration) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		log.DefaultLogger.Warn("cacheSet: value not cached", "key", key, "error", err.Error())
+		return
+	}
+	c.Set(key, b, ttl)
+}
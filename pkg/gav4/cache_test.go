@@ -0,0 +1,64 @@
+package gav4
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheGetSet(t *testing.T) {
+	c := NewMemoryCache(time.Minute, 0)
+	cacheSet(c, "values", []string{"a", "b"}, time.Minute)
+	got, found := cacheGet[[]string](c, "values")
+	if !found || len(got) != 2 || got[1] != "b" {
+		t.Errorf("cacheGet = %v, %v", got, found)
+	}
+	c.Set("broken", []byte("{"), time.Minute)
+	if _, found := cacheGet[[]string](c, "broken"); found {
+		t.Errorf("undecodable entry must be treated as missing")
+	}
+}
+
+func TestCacheStatsAndPurge(t *testing.T) {
+	for name, c := range map[string]Cache{
+		"memory": NewMemoryCache(time.Minute, 0),
+		"disk": func() Cache {
+			disk, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), time.Minute, 0)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			t.Cleanup(func() { disk.Close() })
+			return disk
+		}(),
+	} {
+		ga := &GoogleAnalytics{Cache: WithStats(c)}
+		ga.Cache.Set(newCacheKey(CacheNamespaceMetadata, "fp", "123", "metrics"), []byte("1234"), time.Minute)
+		ga.Cache.Set(newCacheKey(CacheNamespaceReports, "fp", "properties/123", "hash"), []byte("12"), time.Minute)
+		ga.Cache.Set(newCacheKey(CacheNamespaceReports, "fp", "properties/456", "hash"), []byte("12"), time.Minute)
+		ga.Cache.Get(newCacheKey(CacheNamespaceReports, "fp", "properties/123", "hash"))
+		ga.Cache.Get(newCacheKey(CacheNamespaceReports, "fp", "properties/789", "hash"))
+
+		stats := ga.CacheStats()
+		reports := stats.Namespaces[CacheNamespaceReports]
+		if reports.Entries != 2 || reports.Bytes != 4 || reports.Hits != 1 || reports.Misses != 1 || reports.HitRatio != 0.5 {
+			t.Errorf("%s: reports stats = %+v", name, reports)
+		}
+		if stats.Total.Entries != 3 || stats.Total.Bytes != 8 {
+			t.Errorf("%s: total stats = %+v", name, stats.Total)
+		}
+
+		// "123" and "properties/123" are the same property
+		if n, err := ga.PurgeCache("", "properties/123"); err != nil || n != 2 {
+			t.Errorf("%s: purge property = %d, %v", name, n, err)
+		}
+		if n, err := ga.PurgeCache(CacheNamespaceReports, ""); err != nil || n != 1 {
+			t.Errorf("%s: purge namespace = %d, %v", name, n, err)
+		}
+		if _, err := ga.PurgeCache("unknown", ""); err == nil {
+			t.Errorf("%s: unknown namespace should be rejected", name)
+		}
+		if stats := ga.CacheStats(); stats.Total.Entries != 0 {
+			t.Errorf("%s: %d entries left", name, stats.Total.Entries)
+		}
+	}
+}
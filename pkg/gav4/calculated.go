@@ -0,0 +1,315 @@
+package gav4
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// calcExpr evaluates a calculated field for one row. ok is false when the
+// result is null: a metric without a value or a division by zero.
+type calcExpr func(row map[string]float64) (value float64, ok bool)
+
+// calcFunctions are the functions a calculated field can call, with their
+// minimum and maximum number of arguments.
+var calcFunctions = map[string]struct {
+	min, max int
+	fn       func(args []float64) float64
+}{
+	"abs":   {1, 1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"ceil":  {1, 1, func(args []float64) float64 { return math.Ceil(args[0]) }},
+	"floor": {1, 1, func(args []float64) float64 { return math.Floor(args[0]) }},
+	"min":   {2, 2, func(args []float64) float64 { return math.Min(args[0], args[1]) }},
+	"max":   {2, 2, func(args []float64) float64 { return math.Max(args[0], args[1]) }},
+	"round": {1, 2, func(args []float64) float64 {
+		if len(args) == 1 {
+			return math.Round(args[0])
+		}
+		scale := math.Pow(10, math.Trunc(args[1]))
+		return math.Round(args[0]*scale) / scale
+	}},
+}
+
+// calcParser compiles expressions such as round(purchaseRevenue / activeUsers, 2)
+// with + - * /, parentheses, numbers, metric names and calcFunctions.
+type calcParser struct {
+	input string
+	pos   int
+	// fields are the names the expression may refer to
+	fields map[string]bool
+}
+
+func (p *calcParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("column %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *calcParser) skipSpaces() {
+	for p.pos < len(p.input) && (p.input[p.pos] == ' ' || p.input[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// accept consumes c when it is the next non-space character.
+func (p *calcParser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.input) && p.input[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func compileCalcExpr(expression string, fields map[string]bool) (calcExpr, error) {
+	p := &calcParser{input: expression, fields: fields}
+	expr, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:p.pos+1])
+	}
+	return expr, nil
+}
+
+func (p *calcParser) sum() (calcExpr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept('+'):
+			op = '+'
+		case p.accept('-'):
+			op = '-'
+		default:
+			return left, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]float64) (float64, bool) {
+			a, ok := l(row)
+			if !ok {
+				return 0, false
+			}
+			b, ok := right(row)
+			if !ok {
+				return 0, false
+			}
+			if op == '+' {
+				return a + b, true
+			}
+			return a - b, true
+		}
+	}
+}
+
+func (p *calcParser) product() (calcExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept('*'):
+			op = '*'
+		case p.accept('/'):
+			op = '/'
+		default:
+			return left, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(row map[string]float64) (float64, bool) {
+			a, ok := l(row)
+			if !ok {
+				return 0, false
+			}
+			b, ok := right(row)
+			if !ok {
+				return 0, false
+			}
+			if op == '*' {
+				return a * b, true
+			}
+			if b == 0 {
+				return 0, false
+			}
+			return a / b, true
+		}
+	}
+}
+
+func (p *calcParser) unary() (calcExpr, error) {
+	if p.accept('-') {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(row map[string]float64) (float64, bool) {
+			v, ok := operand(row)
+			return -v, ok
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *calcParser) primary() (calcExpr, error) {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+	start := p.pos
+	c := p.input[p.pos]
+	switch {
+	case c == '(':
+		p.pos++
+		expr, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("expected )")
+		}
+		return expr, nil
+	case isDigit(c) || c == '.':
+		for p.pos < len(p.input) && (isDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		text := p.input[start:p.pos]
+		v, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", text)
+		}
+		return func(map[string]float64) (float64, bool) { return v, true }, nil
+	case isFieldNameChar(c):
+		for p.pos < len(p.input) && isFieldNameChar(p.input[p.pos]) {
+			p.pos++
+		}
+		name := p.input[start:p.pos]
+		if p.accept('(') {
+			return p.call(name, start)
+		}
+		if !p.fields[name] {
+			p.pos = start
+			return nil, p.errorf("unknown metric %q", name)
+		}
+		return func(row map[string]float64) (float64, bool) {
+			v, ok := row[name]
+			return v, ok
+		}, nil
+	default:
+		return nil, p.errorf("unexpected %q", p.input[p.pos:p.pos+1])
+	}
+}
+
+func (p *calcParser) call(name string, start int) (calcExpr, error) {
+	function, ok := calcFunctions[strings.ToLower(name)]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown function %q", name)
+	}
+	var args []calcExpr
+	if !p.accept(')') {
+		for {
+			arg, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(')') {
+				break
+			}
+			if !p.accept(',') {
+				return nil, p.errorf("expected , or )")
+			}
+		}
+	}
+	if len(args) < function.min || len(args) > function.max {
+		p.pos = start
+		return nil, p.errorf("%s takes %d to %d arguments, got %d", name, function.min, function.max, len(args))
+	}
+	return func(row map[string]float64) (float64, bool) {
+		values := make([]float64, len(args))
+		for i, arg := range args {
+			v, ok := arg(row)
+			if !ok {
+				return 0, false
+			}
+			values[i] = v
+		}
+		return function.fn(values), true
+	}, nil
+}
+
+// applyCalculatedFields appends a metric column per calculated field to the
+// report, evaluated row by row. A field may use the metrics of the report and
+// the calculated fields before it. Null results are left empty, which the
+// number converter turns into null. Errors are invalid queries.
+func applyCalculatedFields(report *analyticsdata.RunReportResponse, fields []model.CalculatedField) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, header := range report.MetricHeaders {
+		known[header.Name] = true
+	}
+	for _, dimension := range report.DimensionHeaders {
+		known[dimension.Name] = false
+	}
+
+	exprs := make([]calcExpr, len(fields))
+	for i, field := range fields {
+		if field.Name == "" {
+			return invalidQuery(fmt.Errorf("calculated field %d has no name", i+1))
+		}
+		if _, ok := known[field.Name]; ok {
+			return invalidQuery(fmt.Errorf("calculated field %q has the name of a report column", field.Name))
+		}
+		expr, err := compileCalcExpr(field.Expression, known)
+		if err != nil {
+			return invalidQuery(fmt.Errorf("calculated field %q: %w", field.Name, err))
+		}
+		exprs[i] = expr
+		known[field.Name] = true
+	}
+
+	metricCount := len(report.MetricHeaders)
+	for _, row := range report.Rows {
+		values := make(map[string]float64, metricCount+len(fields))
+		for i, value := range row.MetricValues {
+			if i >= metricCount {
+				break
+			}
+			if v, err := strconv.ParseFloat(value.Value, 64); err == nil {
+				values[report.MetricHeaders[i].Name] = v
+			}
+		}
+		for i, field := range fields {
+			value := &analyticsdata.MetricValue{}
+			if v, ok := exprs[i](values); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+				values[field.Name] = v
+				value.Value = strconv.FormatFloat(v, 'f', -1, 64)
+			}
+			row.MetricValues = append(row.MetricValues, value)
+		}
+	}
+	for _, field := range fields {
+		report.MetricHeaders = append(report.MetricHeaders, &analyticsdata.MetricHeader{Name: field.Name, Type: "TYPE_FLOAT"})
+	}
+	return nil
+}
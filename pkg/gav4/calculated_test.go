@@ -0,0 +1,129 @@
+package gav4
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestCompileCalcExpr(t *testing.T) {
+	fields := map[string]bool{"a": true, "b": true, "customEvent:c": true}
+	row := map[string]float64{"a": 7, "b": 2, "customEvent:c": 0}
+	tests := []struct {
+		expression string
+		want       float64
+		null       bool
+	}{
+		{"a + b * 3", 13, false},
+		{"(a + b) * 3", 27, false},
+		{"-a / b", -3.5, false},
+		{"round(a / 3, 2)", 2.33, false},
+		{"max(a, b) - min(a, b)", 5, false},
+		{"a / customEvent:c", 0, true},
+		{"floor(a / (b - 2))", 0, true},
+	}
+	for _, tt := range tests {
+		expr, err := compileCalcExpr(tt.expression, fields)
+		if err != nil {
+			t.Errorf("compileCalcExpr(%q) error: %v", tt.expression, err)
+			continue
+		}
+		got, ok := expr(row)
+		if ok == tt.null || (ok && got != tt.want) {
+			t.Errorf("%q = %v (ok %v), want %v (null %v)", tt.expression, got, ok, tt.want, tt.null)
+		}
+	}
+}
+
+func TestCompileCalcExpr_Errors(t *testing.T) {
+	fields := map[string]bool{"a": true}
+	tests := []struct {
+		in, want string
+	}{
+		{"a +", "column 4: unexpected end of expression"},
+		{"a + z", "column 5: unknown metric \"z\""},
+		{"sqrt(a)", "column 1: unknown function \"sqrt\""},
+		{"round()", "column 1: round takes 1 to 2 arguments, got 0"},
+		{"(a", "column 3: expected )"},
+		{"a $ 2", "column 3: unexpected \"$\""},
+	}
+	for _, tt := range tests {
+		_, err := compileCalcExpr(tt.in, fields)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("compileCalcExpr(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestApplyCalculatedFields(t *testing.T) {
+	newReport := func() *analyticsdata.RunReportResponse {
+		return &analyticsdata.RunReportResponse{
+			DimensionHeaders: []*analyticsdata.DimensionHeader{{Name: "date"}},
+			MetricHeaders: []*analyticsdata.MetricHeader{
+				{Name: "purchaseRevenue", Type: "TYPE_CURRENCY"},
+				{Name: "activeUsers", Type: "TYPE_INTEGER"},
+			},
+			Rows: []*analyticsdata.Row{
+				{
+					DimensionValues: []*analyticsdata.DimensionValue{{Value: "20240101"}},
+					MetricValues:    []*analyticsdata.MetricValue{{Value: "100"}, {Value: "3"}},
+				},
+				{
+					DimensionValues: []*analyticsdata.DimensionValue{{Value: "20240102"}},
+					MetricValues:    []*analyticsdata.MetricValue{{Value: "50"}, {Value: "0"}},
+				},
+			},
+		}
+	}
+	fields := []model.CalculatedField{
+		{Name: "arpu", Expression: "round(purchaseRevenue / activeUsers, 2)"},
+		{Name: "arpuCents", Expression: "arpu * 100"},
+	}
+
+	table := newReport()
+	if err := applyCalculatedFields(table, fields); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frames, err := transformReportsResponseToDataFrames(context.Background(), table, "A", "UTC", model.TABLE, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("transform error: %v", err)
+	}
+	frame := (*frames)[0]
+	arpu, _ := frame.FieldByName("arpu")
+	if arpu == nil || arpu.Len() != 2 {
+		t.Fatalf("expected an arpu field with 2 rows, got %v", frame.Fields)
+	}
+	if v := arpu.At(0).(*float64); v == nil || *v != 33.33 {
+		t.Errorf("arpu[0] = %v, want 33.33", v)
+	}
+	if v := arpu.At(1).(*float64); v != nil {
+		t.Errorf("arpu[1] = %v, want null for a division by zero", *v)
+	}
+	cents, _ := frame.FieldByName("arpuCents")
+	if v := cents.At(0).(*float64); v == nil || *v != 3333 {
+		t.Errorf("arpuCents[0] = %v, want 3333", v)
+	}
+
+	timeSeries := newReport()
+	if err := applyCalculatedFields(timeSeries, fields[:1]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frames, err = transformReportsResponseToDataFrames(context.Background(), timeSeries, "A", "UTC", model.TIME_SERIES, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("transform error: %v", err)
+	}
+	if _, i := (*frames)[0].FieldByName("arpu"); i < 0 {
+		t.Errorf("time series frame misses arpu: %v", (*frames)[0].Fields)
+	}
+
+	if err := applyCalculatedFields(newReport(), []model.CalculatedField{{Name: "activeUsers", Expression: "1"}}); err == nil {
+		t.Error("a calculated field named like a metric should fail")
+	}
+	if err := applyCalculatedFields(newReport(), []model.CalculatedField{{Name: "x", Expression: "date * 2"}}); err == nil {
+		t.Error("a calculated field over a dimension should fail")
+	}
+}
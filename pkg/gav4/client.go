@@ -3,15 +3,20 @@ package gav4
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/auth"
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/option"
 
+	analyticsadminalpha "google.golang.org/api/analyticsadmin/v1alpha"
 	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
 	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
 )
@@ -19,6 +24,22 @@ import (
 type GoogleClient struct {
 	analyticsdata  *analyticsdata.Service
 	analyticsadmin *analyticsadmin.Service
+	// identity is the credential fingerprint, it tells cached and shared
+	// responses of different credentials apart
+	identity string
+
+	// change history is only readable with the edit scope, so that client
+	// is created on first use instead of for every request
+	resolved           *auth.Resolved
+	analyticsadminEdit *analyticsadmin.Service
+	editOnce           sync.Once
+	editErr            error
+
+	// calculated metrics are only exposed by the v1alpha Admin API, which
+	// only metadata needs, so it is created on first use as well
+	analyticsadminAlpha *analyticsadminalpha.Service
+	alphaOnce           sync.Once
+	alphaErr            error
 }
 
 // filterHasContent returns true only when the filter expression contains at
@@ -38,31 +59,83 @@ func NewGoogleClient(ctx context.Context, config *setting.DatasourceSecretSettin
 	if err != nil {
 		return nil, err
 	}
-	analyticsadminService, err := createAnalyticsadminService(ctx, resolved)
+	analyticsadminService, err := createAnalyticsadminService(ctx, resolved, analyticsadmin.AnalyticsReadonlyScope)
 	if err != nil {
 		return nil, err
 	}
-	return &GoogleClient{analyticsdataService, analyticsadminService}, nil
+	return &GoogleClient{
+		analyticsdata:  analyticsdataService,
+		analyticsadmin: analyticsadminService,
+		identity:       resolved.Fingerprint(),
+		resolved:       resolved,
+	}, nil
+}
+
+// editService returns the Admin client with the edit scope, creating it on
+// the first call. Only change history needs it, and with it Editor access of
+// the service account to the GA account; everything else is read-only.
+func (client *GoogleClient) editService(ctx context.Context) (*analyticsadmin.Service, error) {
+	client.editOnce.Do(func() {
+		client.analyticsadminEdit, client.editErr = createAnalyticsadminService(ctx, client.resolved, analyticsadmin.AnalyticsEditScope)
+	})
+	return client.analyticsadminEdit, client.editErr
+}
+
+// alphaService returns the v1alpha Admin client, creating it on the first
+// call.
+func (client *GoogleClient) alphaService(ctx context.Context) (*analyticsadminalpha.Service, error) {
+	client.alphaOnce.Do(func() {
+		client.analyticsadminAlpha, client.alphaErr = createAnalyticsadminAlphaService(ctx, client.resolved)
+	})
+	return client.analyticsadminAlpha, client.alphaErr
+}
+
+// credentialFingerprint scopes cache keys to the datasource credentials
+// without putting any secret into the key.
+func credentialFingerprint(config *setting.DatasourceSecretSettings) (string, error) {
+	resolved, err := auth.Resolve(config)
+	if err != nil {
+		return "", err
+	}
+	return resolved.Fingerprint(), nil
+}
+
+// apiMiddlewares trace every call of the Google API clients, then retry and
+// instrument it.
+func apiMiddlewares() []httpclient.Middleware {
+	return []httpclient.Middleware{httpclient.TracingMiddleware(nil), apiMiddleware()}
 }
 
 func createAnalyticsdataService(ctx context.Context, r *auth.Resolved) (*analyticsdata.Service, error) {
-	httpClient, err := auth.NewHTTPClient(ctx, r, []string{analyticsdata.AnalyticsReadonlyScope})
+	httpClient, err := auth.NewHTTPClient(ctx, r, []string{analyticsdata.AnalyticsReadonlyScope}, apiMiddlewares()...)
 	if err != nil {
 		return nil, err
 	}
 	return analyticsdata.NewService(ctx, option.WithHTTPClient(httpClient))
 }
 
-func createAnalyticsadminService(ctx context.Context, r *auth.Resolved) (*analyticsadmin.Service, error) {
-	httpClient, err := auth.NewHTTPClient(ctx, r, []string{analyticsadmin.AnalyticsReadonlyScope})
+func createAnalyticsadminService(ctx context.Context, r *auth.Resolved, scope string) (*analyticsadmin.Service, error) {
+	httpClient, err := auth.NewHTTPClient(ctx, r, []string{scope}, apiMiddlewares()...)
 	if err != nil {
 		return nil, err
 	}
 	return analyticsadmin.NewService(ctx, option.WithHTTPClient(httpClient))
 }
 
-func (client *GoogleClient) GetWebProperty(webpropertyID string) (*analyticsadmin.GoogleAnalyticsAdminV1betaProperty, error) {
-	webproperty, err := client.analyticsadmin.Properties.Get(webpropertyID).Do()
+func createAnalyticsadminAlphaService(ctx context.Context, r *auth.Resolved) (*analyticsadminalpha.Service, error) {
+	httpClient, err := auth.NewHTTPClient(ctx, r, []string{analyticsadminalpha.AnalyticsReadonlyScope}, apiMiddlewares()...)
+	if err != nil {
+		return nil, err
+	}
+	return analyticsadminalpha.NewService(ctx, option.WithHTTPClient(httpClient))
+}
+
+func (client *GoogleClient) GetWebProperty(ctx context.Context, webpropertyID string) (*analyticsadmin.GoogleAnalyticsAdminV1betaProperty, error) {
+	webproperty, _, err := dedupe(ctx, client, "property|"+webpropertyID, func() (*analyticsadmin.GoogleAnalyticsAdminV1betaProperty, error) {
+		ctx, cancel := flightContext(ctx, GaMetadataTimeout)
+		defer cancel()
+		return client.analyticsadmin.Properties.Get(webpropertyID).Context(ctx).Do()
+	})
 	if err != nil {
 		log.DefaultLogger.Error("GetWebProperty fail", "error", err.Error())
 		return nil, err
@@ -71,9 +144,27 @@ func (client *GoogleClient) GetWebProperty(webpropertyID string) (*analyticsadmi
 	return webproperty, nil
 }
 
-func (client *GoogleClient) getReport(query model.QueryModel) (*analyticsdata.RunReportResponse, error) {
-	defer util.Elapsed("Get report data at GA API")()
-	log.DefaultLogger.Debug("getReport", "queries", query)
+// reportOrderBys is the row order of a report: the query's own order, or the
+// first dimension.
+func reportOrderBys(query model.QueryModel) []*analyticsdata.OrderBy {
+	if len(query.OrderBys) > 0 {
+		return query.OrderBys
+	}
+	if len(query.Dimensions) > 0 {
+		return []*analyticsdata.OrderBy{
+			{
+				Dimension: &analyticsdata.DimensionOrderBy{
+					DimensionName: query.Dimensions[0],
+				},
+			},
+		}
+	}
+	return nil
+}
+
+// newReportRequest builds the RunReport request for query. The same query
+// always yields the same request, which makes it usable as a cache key.
+func newReportRequest(query model.QueryModel) *analyticsdata.RunReportRequest {
 	Metrics := []*analyticsdata.Metric{}
 	Dimensions := []*analyticsdata.Dimension{}
 	for _, metric := range query.Metrics {
@@ -88,51 +179,90 @@ func (client *GoogleClient) getReport(query model.QueryModel) (*analyticsdata.Ru
 			// Create the DateRange object.
 			{StartDate: query.StartDate, EndDate: query.EndDate},
 		},
-		Metrics:       Metrics,
-		Dimensions:    Dimensions,
-		Offset:        offset,
-		KeepEmptyRows: true,
-		Limit:         GaReportMaxResult,
+		Metrics:             Metrics,
+		Dimensions:          Dimensions,
+		Offset:              offset,
+		KeepEmptyRows:       true,
+		Limit:               GaReportMaxResult,
+		ReturnPropertyQuota: true,
 	}
-	if len(query.Dimensions) > 0 {
-		req.OrderBys = []*analyticsdata.OrderBy{
-			{
-				Dimension: &analyticsdata.DimensionOrderBy{
-					DimensionName: query.Dimensions[0],
-				},
-			},
-		}
+	if query.Limit > 0 && query.Limit < GaReportMaxResult {
+		req.Limit = query.Limit
 	}
+	req.OrderBys = reportOrderBys(query)
 	if filterHasContent(query.DimensionFilter) {
 		req.DimensionFilter = query.DimensionFilter
 	}
 	if filterHasContent(query.MetricFilter) {
 		req.MetricFilter = query.MetricFilter
 	}
+	return &req
+}
+
+func (client *GoogleClient) getReport(ctx context.Context, query model.QueryModel) (*analyticsdata.RunReportResponse, error) {
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GoogleClient.getReport", trace.WithAttributes(queryAttributes(query)...))
+	defer span.End()
+
+	key := requestKey("runReport", query.WebPropertyID, newReportRequest(query))
+	flight, err := dedupeCopy(ctx, client, key, func() (*pagedReport, error) {
+		// the call serves every caller waiting on it, so the first one going
+		// away must not cancel it
+		ctx, cancel := flightContext(ctx, GaReportTimeout)
+		defer cancel()
+		report, pages, err := client.runReport(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		reportPages.Observe(float64(pages))
+		return &pagedReport{Report: report, Pages: pages}, nil
+	})
+	if err != nil {
+		return nil, tracing.Error(span, err)
+	}
+	span.SetAttributes(attributePages.Int(flight.Pages), attributeRows.Int(len(flight.Report.Rows)))
+	return flight.Report, nil
+}
+
+// pagedReport is a report with the number of pages it took
+type pagedReport struct {
+	Report *analyticsdata.RunReportResponse
+	Pages  int
+}
+
+// runReport fetches every page of the report and returns the number of pages
+func (client *GoogleClient) runReport(ctx context.Context, query model.QueryModel) (*analyticsdata.RunReportResponse, int, error) {
+	defer util.Elapsed("Get report data at GA API")()
+	log.DefaultLogger.Debug("getReport", "queries", query)
+	req := newReportRequest(query)
 	log.DefaultLogger.Debug("Doing GET request from analytics reporting", "req", req)
 	// Call the BatchGet method and return the response.
-	report, err := client.analyticsdata.Properties.RunReport(query.WebPropertyID, &req).Do()
+	report, err := client.analyticsdata.Properties.RunReport(query.WebPropertyID, req).Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return nil, 0, fmt.Errorf("%w", err)
 	}
+	reportRows.WithLabelValues("runReport").Add(float64(len(report.Rows)))
+	observeQuota(query.WebPropertyID, report.PropertyQuota)
 	//  TODO 페이지 네이션
 	log.DefaultLogger.Debug("Do GET report", "report len", report.RowCount, "report", report)
 
-	if report.RowCount > (query.Offset + GaReportMaxResult) {
+	// A query limit is a row limit, not a page size
+	if query.Limit == 0 && report.RowCount > (query.Offset+GaReportMaxResult) {
 		query.Offset = query.Offset + GaReportMaxResult
-		newReport, err := client.getReport(query)
+		newReport, pages, err := client.runReport(ctx, query)
 		if err != nil {
-			return nil, fmt.Errorf("%w", err)
+			return nil, 0, fmt.Errorf("%w", err)
 		}
 
 		report.Rows = append(report.Rows, newReport.Rows...)
-		return report, nil
+		return report, pages + 1, nil
 	}
-	return report, nil
+	return report, 1, nil
 }
 
-func (client *GoogleClient) getRealtimeReport(query model.QueryModel) (*analyticsdata.RunRealtimeReportResponse, error) {
+func (client *GoogleClient) getRealtimeReport(ctx context.Context, query model.QueryModel) (*analyticsdata.RunRealtimeReportResponse, error) {
 	defer util.Elapsed("Get getRealtimeReport data at GA API")()
+	ctx, span := tracing.DefaultTracer().Start(ctx, "GoogleClient.getRealtimeReport", trace.WithAttributes(queryAttributes(query)...))
+	defer span.End()
 	log.DefaultLogger.Debug("getRealtimeReport", "queries", query)
 	Metrics := []*analyticsdata.Metric{}
 	Dimensions := []*analyticsdata.Dimension{}
@@ -143,53 +273,20 @@ func (client *GoogleClient) getRealtimeReport(query model.QueryModel) (*analytic
 		Dimensions = append(Dimensions, &analyticsdata.Dimension{Name: dimension})
 	}
 
-	end := time.Since(query.To)
-	start := time.Since(query.From)
-
-	log.DefaultLogger.Debug("getRealtimeReport", "start", start.Minutes())
-	log.DefaultLogger.Debug("getRealtimeReport", "end", end.Minutes())
-
-	var (
-		min = GaRealTimeMinMinute
-		max = GaRealTimeMaxMinute
-	)
-
-	if query.ServiceLevel == model.ServiceLevelPremium {
-		max = Ga360RealTimeMaxMinute
-	}
-
-	if end < min {
-		end = min
-	}
-
-	if start > max {
-		start = max
+	minuteRanges, err := realtimeMinuteRanges(query)
+	if err != nil {
+		return nil, tracing.Error(span, err)
 	}
 
-	log.DefaultLogger.Debug("getRealtimeReport", "after start", start.Minutes())
-	log.DefaultLogger.Debug("getRealtimeReport", "after end", end.Minutes())
-
-	log.DefaultLogger.Debug("getRealtimeReport", "real start", int64(start.Minutes()))
-	log.DefaultLogger.Debug("getRealtimeReport", "real end", int64(end.Minutes()))
+	log.DefaultLogger.Debug("getRealtimeReport", "minute ranges", minuteRanges)
 	req := analyticsdata.RunRealtimeReportRequest{
-		Metrics:    Metrics,
-		Dimensions: Dimensions,
-		MinuteRanges: []*analyticsdata.MinuteRange{
-			{
-				EndMinutesAgo:   int64(end.Minutes()),
-				StartMinutesAgo: int64(start.Minutes()),
-			},
-		},
-	}
-	if len(query.Dimensions) > 0 {
-		req.OrderBys = []*analyticsdata.OrderBy{
-			{
-				Dimension: &analyticsdata.DimensionOrderBy{
-					DimensionName: query.Dimensions[0],
-				},
-			},
-		}
+		Metrics:             Metrics,
+		Dimensions:          Dimensions,
+		MinuteRanges:        minuteRanges,
+		Limit:               query.Limit,
+		ReturnPropertyQuota: true,
 	}
+	req.OrderBys = reportOrderBys(query)
 	if filterHasContent(query.DimensionFilter) {
 		req.DimensionFilter = query.DimensionFilter
 	}
@@ -198,26 +295,103 @@ func (client *GoogleClient) getRealtimeReport(query model.QueryModel) (*analytic
 	}
 	log.DefaultLogger.Debug("Doing GET request from analytics reporting", "req", req)
 	// Call the BatchGet method and return the response.
-	report, err := client.analyticsdata.Properties.RunRealtimeReport(query.WebPropertyID, &req).Do()
+	report, err := client.analyticsdata.Properties.RunRealtimeReport(query.WebPropertyID, &req).Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("%w", err)
+		return nil, tracing.Error(span, fmt.Errorf("%w", err))
 	}
+	reportRows.WithLabelValues("runRealtimeReport").Add(float64(len(report.Rows)))
+	observeQuota(query.WebPropertyID, report.PropertyQuota)
 	//  TODO 페이지 네이션
 	log.DefaultLogger.Debug("Do GET report", "report len", report.RowCount, "report", report)
 
 	if report.RowCount > (query.Offset + GaReportMaxResult) {
 		query.Offset = query.Offset + GaReportMaxResult
-		newReport, err := client.getReport(query)
+		newReport, err := client.getReport(ctx, query)
 		if err != nil {
-			return nil, fmt.Errorf("%w", err)
+			return nil, tracing.Error(span, fmt.Errorf("%w", err))
 		}
 
 		report.Rows = append(report.Rows, newReport.Rows...)
+		span.SetAttributes(attributeRows.Int(len(report.Rows)))
 		return report, nil
 	}
+	span.SetAttributes(attributeRows.Int(len(report.Rows)))
 	return report, nil
 }
 
+// realtimeMaxMinute returns how far back the property's service level can query.
+func realtimeMaxMinute(query model.QueryModel) time.Duration {
+	if query.ServiceLevel == model.ServiceLevelPremium {
+		return Ga360RealTimeMaxMinute
+	}
+	return GaRealTimeMaxMinute
+}
+
+// realtimeMinuteRange converts the query time range into minutes ago, clamped
+// to the window the property's service level allows.
+func realtimeMinuteRange(query model.QueryModel) (int64, int64) {
+	end := time.Since(query.To)
+	start := time.Since(query.From)
+
+	log.DefaultLogger.Debug("getRealtimeReport", "start", start.Minutes())
+	log.DefaultLogger.Debug("getRealtimeReport", "end", end.Minutes())
+
+	var (
+		min = GaRealTimeMinMinute
+		max = realtimeMaxMinute(query)
+	)
+
+	if end < min {
+		end = min
+	}
+
+	if start > max {
+		start = max
+	}
+
+	return int64(start.Minutes()), int64(end.Minutes())
+}
+
+// realtimeMinuteRanges returns the minute ranges of a realtime request: the
+// query's named ranges when it has any, otherwise the time picker range.
+func realtimeMinuteRanges(query model.QueryModel) ([]*analyticsdata.MinuteRange, error) {
+	if len(query.MinuteRanges) == 0 {
+		start, end := realtimeMinuteRange(query)
+		return []*analyticsdata.MinuteRange{
+			{
+				EndMinutesAgo:   end,
+				StartMinutesAgo: start,
+			},
+		}, nil
+	}
+	if len(query.MinuteRanges) > GaRealTimeMaxRanges {
+		return nil, fmt.Errorf("realtime query supports at most %d minute ranges, got %d", GaRealTimeMaxRanges, len(query.MinuteRanges))
+	}
+
+	max := int64(realtimeMaxMinute(query).Minutes())
+	minuteRanges := make([]*analyticsdata.MinuteRange, len(query.MinuteRanges))
+	for i, minuteRange := range query.MinuteRanges {
+		start, end := minuteRange.StartMinutesAgo, minuteRange.EndMinutesAgo
+		if start > max {
+			start = max
+		}
+		if end < 0 {
+			end = 0
+		}
+		if end > start {
+			return nil, fmt.Errorf("minute range %q ends before it starts", minuteRange.Name)
+		}
+		minuteRanges[i] = &analyticsdata.MinuteRange{
+			Name:            minuteRange.Name,
+			StartMinutesAgo: start,
+			EndMinutesAgo:   end,
+			// 0 is a valid minute ago, it must not be dropped as an empty value
+			ForceSendFields: []string{"StartMinutesAgo", "EndMinutesAgo"},
+		}
+	}
+	return minuteRanges, nil
+}
+
 // func printResponse(res *reporting.GetReportsResponse) {
 // 	log.DefaultLogger.Debug("Printing Response from analytics reporting", "")
 // 	for _, report := range res.Reports {
@@ -250,20 +424,34 @@ func (client *GoogleClient) getRealtimeReport(query model.QueryModel) (*analytic
 // 	log.DefaultLogger.Info("Completed printing response", "", "")
 // }
 
-func (client *GoogleClient) getMetadata(propertyID string) (*analyticsdata.Metadata, error) {
+func (client *GoogleClient) getMetadata(ctx context.Context, propertyID string) (*analyticsdata.Metadata, error) {
 	if propertyID == "" {
 		propertyID = "0"
 	}
 	nameid := "properties/" + propertyID + "/metadata"
-	metadata, err := client.analyticsdata.Properties.GetMetadata(nameid).Do()
+	metadata, _, err := dedupe(ctx, client, "metadata|"+nameid, func() (*analyticsdata.Metadata, error) {
+		// shared with every concurrent caller, like the report calls
+		ctx, cancel := flightContext(ctx, GaMetadataTimeout)
+		defer cancel()
+		return client.analyticsdata.Properties.GetMetadata(nameid).Context(ctx).Do()
+	})
 	if err != nil {
 		return nil, err
 	}
 	return metadata, nil
 }
 
-func (client *GoogleClient) getAccountSummaries(nextPageToekn string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaAccountSummary, error) {
-	accountSummaries, err := client.analyticsadmin.AccountSummaries.List().PageSize(GaAdminMaxResult).PageToken(nextPageToekn).Do()
+func (client *GoogleClient) getAccountSummaries(ctx context.Context, nextPageToekn string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaAccountSummary, error) {
+	accountSummaries, _, err := dedupe(ctx, client, "accountSummaries|"+nextPageToekn, func() ([]*analyticsadmin.GoogleAnalyticsAdminV1betaAccountSummary, error) {
+		ctx, cancel := flightContext(ctx, GaMetadataTimeout)
+		defer cancel()
+		return client.listAccountSummaries(ctx, nextPageToekn)
+	})
+	return accountSummaries, err
+}
+
+func (client *GoogleClient) listAccountSummaries(ctx context.Context, nextPageToekn string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaAccountSummary, error) {
+	accountSummaries, err := client.analyticsadmin.AccountSummaries.List().PageSize(GaAdminMaxResult).PageToken(nextPageToekn).Context(ctx).Do()
 	if err != nil {
 		log.DefaultLogger.Error("getAccountSummary fail", "error", err.Error())
 		return nil, err
@@ -272,7 +460,7 @@ func (client *GoogleClient) getAccountSummaries(nextPageToekn string) ([]*analyt
 	nextPageToken := accountSummaries.NextPageToken
 
 	if nextPageToken != "" {
-		nextAccountSummaries, err := client.getAccountSummaries(nextPageToken)
+		nextAccountSummaries, err := client.listAccountSummaries(ctx, nextPageToken)
 		if err != nil {
 			return nil, err
 		}
@@ -281,3 +469,170 @@ func (client *GoogleClient) getAccountSummaries(nextPageToekn string) ([]*analyt
 
 	return accountSummaries.AccountSummaries, nil
 }
+
+func (client *GoogleClient) getDataStreams(propertyID string, nextPageToken string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaDataStream, error) {
+	dataStreams, err := client.analyticsadmin.Properties.DataStreams.List(propertyID).PageSize(GaAdminMaxResult).PageToken(nextPageToken).Do()
+	if err != nil {
+		log.DefaultLogger.Error("getDataStreams fail", "error", err.Error())
+		return nil, err
+	}
+
+	if dataStreams.NextPageToken != "" {
+		nextDataStreams, err := client.getDataStreams(propertyID, dataStreams.NextPageToken)
+		if err != nil {
+			return nil, err
+		}
+		dataStreams.DataStreams = append(dataStreams.DataStreams, nextDataStreams...)
+	}
+
+	return dataStreams.DataStreams, nil
+}
+
+func (client *GoogleClient) getCustomDimensions(ctx context.Context, propertyID string, nextPageToken string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomDimension, error) {
+	customDimensions, err := client.analyticsadmin.Properties.CustomDimensions.List(propertyID).PageSize(GaAdminMaxResult).PageToken(nextPageToken).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if customDimensions.NextPageToken != "" {
+		nextCustomDimensions, err := client.getCustomDimensions(ctx, propertyID, customDimensions.NextPageToken)
+		if err != nil {
+			return nil, err
+		}
+		customDimensions.CustomDimensions = append(customDimensions.CustomDimensions, nextCustomDimensions...)
+	}
+
+	return customDimensions.CustomDimensions, nil
+}
+
+func (client *GoogleClient) getCustomMetrics(ctx context.Context, propertyID string, nextPageToken string) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomMetric, error) {
+	customMetrics, err := client.analyticsadmin.Properties.CustomMetrics.List(propertyID).PageSize(GaAdminMaxResult).PageToken(nextPageToken).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if customMetrics.NextPageToken != "" {
+		nextCustomMetrics, err := client.getCustomMetrics(ctx, propertyID, customMetrics.NextPageToken)
+		if err != nil {
+			return nil, err
+		}
+		customMetrics.CustomMetrics = append(customMetrics.CustomMetrics, nextCustomMetrics...)
+	}
+
+	return customMetrics.CustomMetrics, nil
+}
+
+func (client *GoogleClient) getCalculatedMetrics(ctx context.Context, propertyID string, nextPageToken string) ([]*analyticsadminalpha.GoogleAnalyticsAdminV1alphaCalculatedMetric, error) {
+	service, err := client.alphaService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	calculatedMetrics, err := service.Properties.CalculatedMetrics.List(propertyID).PageSize(GaAdminMaxResult).PageToken(nextPageToken).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	if calculatedMetrics.NextPageToken != "" {
+		nextCalculatedMetrics, err := client.getCalculatedMetrics(ctx, propertyID, calculatedMetrics.NextPageToken)
+		if err != nil {
+			return nil, err
+		}
+		calculatedMetrics.CalculatedMetrics = append(calculatedMetrics.CalculatedMetrics, nextCalculatedMetrics...)
+	}
+
+	return calculatedMetrics.CalculatedMetrics, nil
+}
+
+// getDimensionValues returns the distinct values a dimension took in the date
+// range, sorted by value, or by descending orderByMetric when one is given.
+func (client *GoogleClient) getDimensionValues(propertyID string, dimension string, startDate string, endDate string, filter *analyticsdata.FilterExpression, orderByMetric string, limit int64) ([]string, error) {
+	defer util.Elapsed("Get dimension values at GA API")()
+	req := analyticsdata.RunReportRequest{
+		DateRanges: []*analyticsdata.DateRange{
+			{StartDate: startDate, EndDate: endDate},
+		},
+		Dimensions: []*analyticsdata.Dimension{{Name: dimension}},
+		OrderBys: []*analyticsdata.OrderBy{
+			{
+				Dimension: &analyticsdata.DimensionOrderBy{
+					DimensionName: dimension,
+				},
+			},
+		},
+		Limit: limit,
+	}
+	if orderByMetric != "" {
+		req.Metrics = []*analyticsdata.Metric{{Name: orderByMetric}}
+		req.OrderBys = []*analyticsdata.OrderBy{
+			{
+				Metric: &analyticsdata.MetricOrderBy{
+					MetricName: orderByMetric,
+				},
+				Desc: true,
+			},
+		}
+	}
+	if filterHasContent(filter) {
+		req.DimensionFilter = filter
+	}
+	log.DefaultLogger.Debug("Doing GET request for dimension values", "req", req)
+	report, err := client.analyticsdata.Properties.RunReport(propertyID, &req).Do()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	values := make([]string, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		if len(row.DimensionValues) > 0 {
+			values = append(values, row.DimensionValues[0].Value)
+		}
+	}
+	return values, nil
+}
+
+func (client *GoogleClient) checkCompatibility(query model.QueryModel) (*analyticsdata.CheckCompatibilityResponse, error) {
+	req := analyticsdata.CheckCompatibilityRequest{}
+	for _, metric := range query.Metrics {
+		req.Metrics = append(req.Metrics, &analyticsdata.Metric{Name: metric})
+	}
+	for _, dimension := range query.Dimensions {
+		req.Dimensions = append(req.Dimensions, &analyticsdata.Dimension{Name: dimension})
+	}
+	if filterHasContent(query.DimensionFilter) {
+		req.DimensionFilter = query.DimensionFilter
+	}
+	if filterHasContent(query.MetricFilter) {
+		req.MetricFilter = query.MetricFilter
+	}
+	res, err := client.analyticsdata.Properties.CheckCompatibility(query.WebPropertyID, &req).Do()
+	if err != nil {
+		log.DefaultLogger.Error("checkCompatibility fail", "error", err.Error())
+		return nil, err
+	}
+	return res, nil
+}
+
+func (client *GoogleClient) searchChangeHistoryEvents(ctx context.Context, accountID string, req *analyticsadmin.GoogleAnalyticsAdminV1betaSearchChangeHistoryEventsRequest) ([]*analyticsadmin.GoogleAnalyticsAdminV1betaChangeHistoryEvent, error) {
+	service, err := client.editService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.PageSize = GaAdminMaxResult
+	res, err := service.Accounts.SearchChangeHistoryEvents(accountID, req).Context(ctx).Do()
+	if err != nil {
+		log.DefaultLogger.Error("searchChangeHistoryEvents fail", "error", err.Error())
+		return nil, fmt.Errorf("%w: %w", errChangeHistory, err)
+	}
+
+	if res.NextPageToken != "" {
+		next := *req
+		next.PageToken = res.NextPageToken
+		nextEvents, err := client.searchChangeHistoryEvents(ctx, accountID, &next)
+		if err != nil {
+			return nil, err
+		}
+		res.ChangeHistoryEvents = append(res.ChangeHistoryEvents, nextEvents...)
+	}
+
+	return res.ChangeHistoryEvents, nil
+}
@@ -0,0 +1,60 @@
+package gav4
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestRealtimeMinuteRanges_DefaultsToTimePicker(t *testing.T) {
+	now := time.Now()
+	got, err := realtimeMinuteRanges(model.QueryModel{From: now.Add(-2 * time.Hour), To: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected one range, got %d", len(got))
+	}
+	if got[0].StartMinutesAgo != int64(GaRealTimeMaxMinute.Minutes()) || got[0].EndMinutesAgo != 0 {
+		t.Errorf("range = %d..%d, want clamped to %d..0", got[0].StartMinutesAgo, got[0].EndMinutesAgo, int64(GaRealTimeMaxMinute.Minutes()))
+	}
+}
+
+func TestRealtimeMinuteRanges_Named(t *testing.T) {
+	got, err := realtimeMinuteRanges(model.QueryModel{
+		ServiceLevel: model.ServiceLevelPremium,
+		MinuteRanges: []*analyticsdata.MinuteRange{
+			{Name: "recent", StartMinutesAgo: 4, EndMinutesAgo: 0},
+			{Name: "baseline", StartMinutesAgo: 90, EndMinutesAgo: 5},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected two ranges, got %d", len(got))
+	}
+	if got[0].Name != "recent" || got[0].StartMinutesAgo != 4 || got[0].EndMinutesAgo != 0 {
+		t.Errorf("first range = %+v", got[0])
+	}
+	if got[1].StartMinutesAgo != int64(Ga360RealTimeMaxMinute.Minutes()) {
+		t.Errorf("second range start = %d, want clamped to %d", got[1].StartMinutesAgo, int64(Ga360RealTimeMaxMinute.Minutes()))
+	}
+}
+
+func TestRealtimeMinuteRanges_Invalid(t *testing.T) {
+	tooMany := make([]*analyticsdata.MinuteRange, GaRealTimeMaxRanges+1)
+	for i := range tooMany {
+		tooMany[i] = &analyticsdata.MinuteRange{StartMinutesAgo: 10}
+	}
+	if _, err := realtimeMinuteRanges(model.QueryModel{MinuteRanges: tooMany}); err == nil {
+		t.Error("expected error for too many minute ranges")
+	}
+
+	reversed := []*analyticsdata.MinuteRange{{Name: "bad", StartMinutesAgo: 1, EndMinutesAgo: 5}}
+	if _, err := realtimeMinuteRanges(model.QueryModel{MinuteRanges: reversed}); err == nil {
+		t.Error("expected error for a range ending before it starts")
+	}
+}
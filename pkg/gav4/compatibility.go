@@ -0,0 +1,104 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+	"google.golang.org/api/googleapi"
+)
+
+// CheckCompatibility reports which dimensions and metrics of the property can
+// be combined with the query's current fields and filters
+func (ga *GoogleAnalytics) CheckCompatibility(ctx context.Context, config *setting.DatasourceSecretSettings, queryModel model.QueryModel) (*model.Compatibility, error) {
+	if len(queryModel.WebPropertyID) == 0 {
+		return nil, fmt.Errorf("required webpropertyid")
+	}
+	if err := ga.checkProperty(ctx, config, queryModel.WebPropertyID); err != nil {
+		return nil, err
+	}
+	queryModel.DimensionFilter = enforceDimensionFilter(config, queryModel.DimensionFilter)
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google API client: %w", err)
+	}
+	res, err := client.checkCompatibility(queryModel)
+	if err != nil {
+		return nil, err
+	}
+	return toCompatibility(res), nil
+}
+
+func toCompatibility(res *analyticsdata.CheckCompatibilityResponse) *model.Compatibility {
+	compatibility := &model.Compatibility{
+		Dimensions: make([]model.FieldCompatibility, 0, len(res.DimensionCompatibilities)),
+		Metrics:    make([]model.FieldCompatibility, 0, len(res.MetricCompatibilities)),
+	}
+	for _, dimension := range res.DimensionCompatibilities {
+		if dimension.DimensionMetadata == nil {
+			continue
+		}
+		compatibility.Dimensions = append(compatibility.Dimensions, model.FieldCompatibility{
+			ID:            dimension.DimensionMetadata.ApiName,
+			UIName:        dimension.DimensionMetadata.UiName,
+			Compatibility: dimension.Compatibility,
+		})
+	}
+	for _, metric := range res.MetricCompatibilities {
+		if metric.MetricMetadata == nil {
+			continue
+		}
+		compatibility.Metrics = append(compatibility.Metrics, model.FieldCompatibility{
+			ID:            metric.MetricMetadata.ApiName,
+			UIName:        metric.MetricMetadata.UiName,
+			Compatibility: metric.Compatibility,
+		})
+	}
+	return compatibility
+}
+
+// incompatibleFields returns the query's own fields GA marks incompatible.
+func incompatibleFields(compatibility *model.Compatibility, queryModel *model.QueryModel) []string {
+	selected := make(map[string]struct{}, len(queryModel.Dimensions)+len(queryModel.Metrics))
+	for _, name := range queryModel.Dimensions {
+		selected[name] = struct{}{}
+	}
+	for _, name := range queryModel.Metrics {
+		selected[name] = struct{}{}
+	}
+
+	var fields []string
+	for _, group := range [][]model.FieldCompatibility{compatibility.Dimensions, compatibility.Metrics} {
+		for _, field := range group {
+			if _, ok := selected[field.ID]; ok && field.Compatibility == GaIncompatible {
+				fields = append(fields, field.ID)
+			}
+		}
+	}
+	return fields
+}
+
+// explainBadRequest adds the incompatible fields of the query to a 400 error
+// from RunReport, which GA otherwise reports without naming them.
+func explainBadRequest(client *GoogleClient, queryModel *model.QueryModel, err error) error {
+	var apiErr *googleapi.Error
+	if queryModel.Mode == model.REALTIME || !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return err
+	}
+	res, checkErr := client.checkCompatibility(*queryModel)
+	if checkErr != nil {
+		log.DefaultLogger.Warn("explainBadRequest: Fail checkCompatibility", "error", checkErr.Error())
+		return err
+	}
+	fields := incompatibleFields(toCompatibility(res), queryModel)
+	if len(fields) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (incompatible fields: %s)", err, strings.Join(fields, ", "))
+}
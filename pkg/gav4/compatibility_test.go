@@ -0,0 +1,37 @@
+package gav4
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestIncompatibleFields(t *testing.T) {
+	res := &analyticsdata.CheckCompatibilityResponse{
+		DimensionCompatibilities: []*analyticsdata.DimensionCompatibility{
+			{Compatibility: "COMPATIBLE", DimensionMetadata: &analyticsdata.DimensionMetadata{ApiName: "date"}},
+			{Compatibility: "INCOMPATIBLE", DimensionMetadata: &analyticsdata.DimensionMetadata{ApiName: "sessionSource"}},
+			{Compatibility: "INCOMPATIBLE", DimensionMetadata: &analyticsdata.DimensionMetadata{ApiName: "notSelected"}},
+			{Compatibility: "INCOMPATIBLE"},
+		},
+		MetricCompatibilities: []*analyticsdata.MetricCompatibility{
+			{Compatibility: "INCOMPATIBLE", MetricMetadata: &analyticsdata.MetricMetadata{ApiName: "itemRevenue"}},
+			{Compatibility: "COMPATIBLE", MetricMetadata: &analyticsdata.MetricMetadata{ApiName: "sessions"}},
+		},
+	}
+	queryModel := &model.QueryModel{
+		Dimensions: []string{"date", "sessionSource"},
+		Metrics:    []string{"itemRevenue", "sessions"},
+	}
+
+	compatibility := toCompatibility(res)
+	if len(compatibility.Dimensions) != 3 || len(compatibility.Metrics) != 2 {
+		t.Fatalf("unexpected compatibility sizes: %+v", compatibility)
+	}
+	got := incompatibleFields(compatibility, queryModel)
+	if want := []string{"sessionSource", "itemRevenue"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("incompatibleFields = %v, want %v", got, want)
+	}
+}
@@ -7,16 +7,37 @@ import (
 )
 
 const (
-	GaDefaultIdx           = 1
-	GaAdminMaxResult       = 200
-	GaReportMaxResult      = 100000
-	GaRealTimeMinMinute    = 0 * time.Minute
-	GaRealTimeMaxMinute    = 29 * time.Minute
-	Ga360RealTimeMaxMinute = 59 * time.Minute
+	GaDefaultIdx        = 1
+	GaAdminMaxResult    = 200
+	GaReportMaxResult   = 100000
+	GaVariableMaxResult = 1000
+
+	GaIncompatible = "INCOMPATIBLE"
+	GaCustomGroup  = "Custom"
+
+	GaMaxConcurrentProperties = 4
+
+	GaReportCacheDefaultDuration = 5 * time.Minute
+	GaDiskCacheDefaultMaxSizeMB  = 100
+	// A shared GA call outlives the caller that started it, up to this long
+	GaReportTimeout   = 2 * time.Minute
+	GaMetadataTimeout = time.Minute
+
+	GaSuggestionDefaultDays  = 30
+	GaSuggestionDefaultLimit = 20
+	GaRealTimeMinMinute      = 0 * time.Minute
+	GaRealTimeMaxMinute      = 29 * time.Minute
+	Ga360RealTimeMaxMinute   = 59 * time.Minute
+	GaRealTimeMaxRanges      = 2
+
+	GaRealTimeStreamDefaultInterval = 10 * time.Second
+	GaRealTimeStreamMinInterval     = 5 * time.Second
 )
 
 // Realtime metrics and dimensions not provided by Google Analytics
 // 리얼타임 메트릭 디멘션은 구글 api에서 제공하지 않기때문에 상수화로 처리
+// They are matched against the property metadata by realtimeItems and served
+// as they are when the metadata is unavailable.
 var GaRealTimeDimensions = []model.MetadataItem{
 	{
 		ID: "appVersion",
@@ -0,0 +1,140 @@
+package gav4
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// currentNames maps every deprecated apiName in the catalogue to the name GA
+// reports it under today, e.g. conversions to keyEvents.
+func currentNames(items ...[]model.MetadataItem) map[string]string {
+	names := map[string]string{}
+	for _, list := range items {
+		for _, item := range list {
+			for _, deprecated := range item.Attributes.DeprecatedAPINames {
+				names[deprecated] = item.ID
+			}
+		}
+	}
+	return names
+}
+
+// calcFieldNamePattern matches the metric names in a calculated field expression
+var calcFieldNamePattern = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_:]*`)
+
+// renameDeprecatedFields rewrites deprecated metric and dimension names of the
+// query, filters and order included, to their current names. It returns the original
+// name of every rewritten field keyed by its current name.
+func renameDeprecatedFields(queryModel *model.QueryModel, names map[string]string) map[string]string {
+	renamed := map[string]string{}
+	rename := func(name string) string {
+		if current, ok := names[name]; ok {
+			renamed[current] = name
+			return current
+		}
+		return name
+	}
+	for i, metric := range queryModel.Metrics {
+		queryModel.Metrics[i] = rename(metric)
+	}
+	for i, dimension := range queryModel.Dimensions {
+		queryModel.Dimensions[i] = rename(dimension)
+	}
+	queryModel.TimeDimension = rename(queryModel.TimeDimension)
+	for i, field := range queryModel.CalculatedFields {
+		queryModel.CalculatedFields[i].Expression = calcFieldNamePattern.ReplaceAllStringFunc(field.Expression, rename)
+	}
+	for _, orderBy := range queryModel.OrderBys {
+		if orderBy.Metric != nil {
+			orderBy.Metric.MetricName = rename(orderBy.Metric.MetricName)
+		}
+		if orderBy.Dimension != nil {
+			orderBy.Dimension.DimensionName = rename(orderBy.Dimension.DimensionName)
+		}
+	}
+	renameFilterFields(queryModel.DimensionFilter, rename)
+	renameFilterFields(queryModel.MetricFilter, rename)
+	return renamed
+}
+
+func renameFilterFields(expression *analyticsdata.FilterExpression, rename func(string) string) {
+	if expression == nil {
+		return
+	}
+	if expression.Filter != nil {
+		expression.Filter.FieldName = rename(expression.Filter.FieldName)
+	}
+	if expression.AndGroup != nil {
+		for _, e := range expression.AndGroup.Expressions {
+			renameFilterFields(e, rename)
+		}
+	}
+	if expression.OrGroup != nil {
+		for _, e := range expression.OrGroup.Expressions {
+			renameFilterFields(e, rename)
+		}
+	}
+	renameFilterFields(expression.NotExpression, rename)
+}
+
+// rewriteDeprecatedFields looks up the property catalogue and renames the
+// deprecated fields of the query, so that saved dashboards keep working after
+// GA removes an old name. Without a catalogue the query is left as is and GA
+// reports the unknown field itself.
+func (ga *GoogleAnalytics) rewriteDeprecatedFields(ctx context.Context, config *setting.DatasourceSecretSettings, queryModel *model.QueryModel) map[string]string {
+	propertyId := strings.TrimPrefix(queryModel.WebPropertyID, "properties/")
+	metrics, dimensions, err := ga.getMetadataItems(ctx, config, propertyId)
+	if err != nil {
+		log.DefaultLogger.Warn("rewriteDeprecatedFields: Fail getMetadataItems", "error", err.Error())
+		return nil
+	}
+	return renameDeprecatedFields(queryModel, currentNames(metrics, dimensions))
+}
+
+// restoreDeprecatedNames shows rewritten fields under the name the query used
+// and tells the user which names to update.
+func restoreDeprecatedNames(frames data.Frames, renamed map[string]string) {
+	if len(renamed) == 0 {
+		return
+	}
+	currents := make([]string, 0, len(renamed))
+	for current := range renamed {
+		currents = append(currents, current)
+	}
+	sort.Strings(currents)
+	replacements := make([]string, len(currents))
+	for i, current := range currents {
+		replacements[i] = renamed[current] + " → " + current
+	}
+	notice := data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Deprecated field names were replaced, update the query: %s", strings.Join(replacements, ", ")),
+	}
+
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			original, ok := renamed[field.Name]
+			if !ok {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			displayName := field.Config.DisplayName
+			if displayName == "" {
+				displayName = field.Name
+			}
+			field.Config.DisplayName = strings.TrimSuffix(displayName, field.Name) + original
+		}
+		frame.AppendNotices(notice)
+	}
+}
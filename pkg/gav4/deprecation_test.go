@@ -0,0 +1,81 @@
+package gav4
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestRenameDeprecatedFields(t *testing.T) {
+	metrics := []model.MetadataItem{
+		{ID: "keyEvents", Attributes: model.MetadataItemAttribute{DeprecatedAPINames: []string{"conversions"}}},
+		{ID: "sessions"},
+	}
+	dimensions := []model.MetadataItem{
+		{ID: "isKeyEvent", Attributes: model.MetadataItemAttribute{DeprecatedAPINames: []string{"isConversionEvent"}}},
+	}
+	qm := &model.QueryModel{
+		Metrics:    []string{"conversions", "sessions"},
+		Dimensions: []string{"isConversionEvent"},
+		DimensionFilter: &analyticsdata.FilterExpression{
+			NotExpression: &analyticsdata.FilterExpression{
+				Filter: &analyticsdata.Filter{FieldName: "isConversionEvent"},
+			},
+		},
+		MetricFilter: &analyticsdata.FilterExpression{
+			Filter: &analyticsdata.Filter{FieldName: "conversions"},
+		},
+		OrderBys: []*analyticsdata.OrderBy{
+			{Metric: &analyticsdata.MetricOrderBy{MetricName: "conversions"}, Desc: true},
+			{Dimension: &analyticsdata.DimensionOrderBy{DimensionName: "isConversionEvent"}},
+		},
+	}
+
+	renamed := renameDeprecatedFields(qm, currentNames(metrics, dimensions))
+
+	if !reflect.DeepEqual(qm.Metrics, []string{"keyEvents", "sessions"}) {
+		t.Errorf("Metrics = %v", qm.Metrics)
+	}
+	if !reflect.DeepEqual(qm.Dimensions, []string{"isKeyEvent"}) {
+		t.Errorf("Dimensions = %v", qm.Dimensions)
+	}
+	if got := qm.DimensionFilter.NotExpression.Filter.FieldName; got != "isKeyEvent" {
+		t.Errorf("dimension filter field = %q", got)
+	}
+	if got := qm.MetricFilter.Filter.FieldName; got != "keyEvents" {
+		t.Errorf("metric filter field = %q", got)
+	}
+	if got := qm.OrderBys[0].Metric.MetricName; got != "keyEvents" {
+		t.Errorf("metric order by = %q", got)
+	}
+	if got := qm.OrderBys[1].Dimension.DimensionName; got != "isKeyEvent" {
+		t.Errorf("dimension order by = %q", got)
+	}
+	want := map[string]string{"keyEvents": "conversions", "isKeyEvent": "isConversionEvent"}
+	if !reflect.DeepEqual(renamed, want) {
+		t.Errorf("renamed = %v, want %v", renamed, want)
+	}
+}
+
+func TestRestoreDeprecatedNames(t *testing.T) {
+	keyEvents := data.NewField("keyEvents", nil, []*float64{})
+	keyEvents.Config = &data.FieldConfig{DisplayName: "US|keyEvents"}
+	sessions := data.NewField("sessions", nil, []*float64{})
+	frame := data.NewFrame("A", keyEvents, sessions)
+
+	restoreDeprecatedNames(data.Frames{frame}, map[string]string{"keyEvents": "conversions"})
+
+	if got := keyEvents.Config.DisplayName; got != "US|conversions" {
+		t.Errorf("DisplayName = %q, want US|conversions", got)
+	}
+	if sessions.Config != nil {
+		t.Errorf("untouched field got config %+v", sessions.Config)
+	}
+	if frame.Meta == nil || len(frame.Meta.Notices) != 1 || !strings.Contains(frame.Meta.Notices[0].Text, "conversions → keyEvents") {
+		t.Errorf("expected a deprecation notice, got %+v", frame.Meta)
+	}
+}
@@ -0,0 +1,249 @@
+package gav4
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+var diskCacheBucket = []byte("entries")
+
+// diskCacheHeader is the expiry, in unix nanoseconds, stored before every value
+const diskCacheHeader = 8
+
+// DiskCache is a Cache kept in a bbolt file, so that metadata and reports
+// survive plugin restarts. Once the entries grow past maxBytes, expired
+// entries and then the ones closest to expiry are dropped.
+type DiskCache struct {
+	file              *diskCacheFile
+	defaultExpiration time.Duration
+	maxBytes          int64
+	closeOnce         sync.Once
+}
+
+// diskCacheFile is an open cache file. bbolt locks the file, so the instances
+// of a datasource share it: Grafana creates the new instance after a settings
+// change before it disposes of the old one.
+type diskCacheFile struct {
+	path string
+	db   *bolt.DB
+	refs int
+
+	mu   sync.Mutex
+	size int64
+}
+
+var (
+	diskCacheFilesMu sync.Mutex
+	diskCacheFiles   = map[string]*diskCacheFile{}
+)
+
+// NewDiskCache opens, or creates, the cache file at path, or shares it with
+// the caches that already have it open. Expired entries left from a previous
+// run are removed.
+func NewDiskCache(path string, defaultExpiration time.Duration, maxBytes int64) (*DiskCache, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	diskCacheFilesMu.Lock()
+	defer diskCacheFilesMu.Unlock()
+	file, ok := diskCacheFiles[path]
+	if !ok {
+		var err error
+		if file, err = openDiskCacheFile(path); err != nil {
+			return nil, err
+		}
+		diskCacheFiles[path] = file
+	}
+	file.refs++
+	return &DiskCache{file: file, defaultExpiration: defaultExpiration, maxBytes: maxBytes}, nil
+}
+
+func openDiskCacheFile(path string) (*diskCacheFile, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	file := &diskCacheFile{path: path, db: db}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(diskCacheBucket)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UnixNano()
+		var expired [][]byte
+		var size int64
+		err = bucket.ForEach(func(k, v []byte) error {
+			if diskCacheExpiry(v) <= now {
+				expired = append(expired, append([]byte{}, k...))
+				return nil
+			}
+			size += int64(len(k) + len(v))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		file.size = size
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+func diskCacheExpiry(v []byte) int64 {
+	if len(v) < diskCacheHeader {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(v[:diskCacheHeader]))
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	var value []byte
+	err := c.file.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(diskCacheBucket).Get([]byte(key))
+		if v == nil || diskCacheExpiry(v) <= time.Now().UnixNano() {
+			return nil
+		}
+		// v is only valid inside the transaction
+		value = append([]byte{}, v[diskCacheHeader:]...)
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("DiskCache: Get failed", "key", key, "error", err.Error())
+		return nil, false
+	}
+	return value, value != nil
+}
+
+func (c *DiskCache) Set(key string, value []byte, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.defaultExpiration
+	}
+	entry := make([]byte, diskCacheHeader+len(value))
+	binary.BigEndian.PutUint64(entry, uint64(time.Now().Add(ttl).UnixNano()))
+	copy(entry[diskCacheHeader:], value)
+
+	// size only changes once the transaction is committed
+	c.file.mu.Lock()
+	defer c.file.mu.Unlock()
+	size := c.file.size
+	err := c.file.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskCacheBucket)
+		if old := bucket.Get([]byte(key)); old != nil {
+			size -= int64(len(key) + len(old))
+		}
+		if err := bucket.Put([]byte(key), entry); err != nil {
+			return err
+		}
+		size += int64(len(key) + len(entry))
+		if c.maxBytes > 0 && size > c.maxBytes {
+			var err error
+			size, err = c.evict(bucket, size)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("DiskCache: Set failed", "key", key, "error", err.Error())
+		return
+	}
+	c.file.size = size
+}
+
+func (c *DiskCache) Delete(key string) {
+	c.file.mu.Lock()
+	defer c.file.mu.Unlock()
+	var removed int64
+	err := c.file.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(diskCacheBucket)
+		if old := bucket.Get([]byte(key)); old != nil {
+			removed = int64(len(key) + len(old))
+		}
+		return bucket.Delete([]byte(key))
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("DiskCache: Delete failed", "key", key, "error", err.Error())
+		return
+	}
+	c.file.size -= removed
+}
+
+func (c *DiskCache) Range(fn func(key string, size int) bool) {
+	now := time.Now().UnixNano()
+	err := c.file.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(diskCacheBucket).Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if diskCacheExpiry(v) <= now {
+				continue
+			}
+			if !fn(string(k), len(v)-diskCacheHeader) {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.DefaultLogger.Warn("DiskCache: Range failed", "error", err.Error())
+	}
+}
+
+// evict drops entries, earliest expiry first, until size is back under 90%
+// of maxBytes, and returns the size left. Expired entries always sort first.
+func (c *DiskCache) evict(bucket *bolt.Bucket, size int64) (int64, error) {
+	type entry struct {
+		key    []byte
+		expiry int64
+		size   int64
+	}
+	var entries []entry
+	err := bucket.ForEach(func(k, v []byte) error {
+		entries = append(entries, entry{append([]byte{}, k...), diskCacheExpiry(v), int64(len(k) + len(v))})
+		return nil
+	})
+	if err != nil {
+		return size, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].expiry < entries[j].expiry })
+
+	target := c.maxBytes * 9 / 10
+	for _, e := range entries {
+		if size <= target {
+			break
+		}
+		if err := bucket.Delete(e.key); err != nil {
+			return size, err
+		}
+		size -= e.size
+	}
+	return size, nil
+}
+
+// Close releases the cache file, which is closed once no cache uses it
+func (c *DiskCache) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		diskCacheFilesMu.Lock()
+		defer diskCacheFilesMu.Unlock()
+		c.file.refs--
+		if c.file.refs > 0 {
+			return
+		}
+		delete(diskCacheFiles, c.file.path)
+		err = c.file.db.Close()
+	})
+	return err
+}
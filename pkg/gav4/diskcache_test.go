@@ -0,0 +1,118 @@
+package gav4
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiskCache_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := NewDiskCache(path, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Set("metadata", []byte("dimensions"), time.Hour)
+	c.Set("report", []byte("rows"), time.Nanosecond)
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, err = NewDiskCache(path, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	if v, found := c.Get("metadata"); !found || !bytes.Equal(v, []byte("dimensions")) {
+		t.Errorf("Get(metadata) = %q, %v", v, found)
+	}
+	if _, found := c.Get("report"); found {
+		t.Errorf("expired entry must not be returned")
+	}
+
+	c.Delete("metadata")
+	if _, found := c.Get("metadata"); found {
+		t.Errorf("deleted entry must not be returned")
+	}
+}
+
+func TestDiskCache_EvictsOverMaxBytes(t *testing.T) {
+	c, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), time.Minute, 4096)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+
+	value := bytes.Repeat([]byte("x"), 512)
+	for i := 0; i < 20; i++ {
+		// later keys expire later, so the first ones are evicted
+		c.Set(fmt.Sprintf("key-%02d", i), value, time.Duration(i+1)*time.Minute)
+	}
+	if c.file.size > 4096 {
+		t.Errorf("size %d is over the limit", c.file.size)
+	}
+	if _, found := c.Get("key-00"); found {
+		t.Errorf("earliest expiring entry should have been evicted")
+	}
+	if _, found := c.Get("key-19"); !found {
+		t.Errorf("latest entry should be kept")
+	}
+}
+
+func TestDiskCache_SharedWhileReplaced(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	old, err := NewDiskCache(path, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	old.Set("metadata", []byte("dimensions"), time.Hour)
+
+	// the new instance opens the file before the old one is disposed of
+	replacement, err := NewDiskCache(path, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("second open of an open cache file failed: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+
+	if v, found := replacement.Get("metadata"); !found || !bytes.Equal(v, []byte("dimensions")) {
+		t.Errorf("Get(metadata) = %q, %v", v, found)
+	}
+	replacement.Set("report", []byte("rows"), time.Hour)
+	if err := replacement.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reopened, err := NewDiskCache(path, time.Minute, 0)
+	if err != nil {
+		t.Fatalf("reopen after the last Close failed: %v", err)
+	}
+	defer reopened.Close()
+	if _, found := reopened.Get("report"); !found {
+		t.Errorf("entry written by the replacement must survive")
+	}
+}
+
+func TestDiskCache_SizeUnchangedOnFailure(t *testing.T) {
+	c, err := NewDiskCache(filepath.Join(t.TempDir(), "cache.db"), time.Minute, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Set("metadata", []byte("dimensions"), time.Hour)
+	size := c.file.size
+	if err := c.file.db.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.Set("report", []byte("rows"), time.Hour)
+	c.Delete("metadata")
+	if c.file.size != size {
+		t.Errorf("size = %d after failed writes, want %d", c.file.size, size)
+	}
+	c.Close()
+}
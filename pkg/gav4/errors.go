@@ -0,0 +1,219 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// errorClass is what went wrong from the user's point of view
+type errorClass string
+
+const (
+	errorClassAuth        errorClass = "authentication failed"
+	errorClassPermission  errorClass = "permission denied"
+	errorClassQuota       errorClass = "quota exhausted"
+	errorClassInvalid     errorClass = "invalid argument"
+	errorClassUnavailable errorClass = "Google Analytics unavailable"
+	errorClassPlugin      errorClass = "plugin error"
+)
+
+// quotaReasons are the googleapi error reasons GA uses for exhausted quotas,
+// some of which come with a 403 instead of a 429.
+var quotaReasons = []string{"rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded", "dailyLimitExceeded", "RATE_LIMIT_EXCEEDED", "RESOURCE_EXHAUSTED"}
+
+// errChangeHistory marks failures of the change history search, which needs
+// more access than the rest of the plugin.
+var errChangeHistory = errors.New("change history")
+
+// invalidFieldPattern finds the field GA complains about in a 400 message such
+// as "Field sessionz is not a valid metric."
+var invalidFieldPattern = regexp.MustCompile(`[Ff]ield ([A-Za-z][A-Za-z0-9_:]*) is not a valid`)
+
+// classifiedError is a query failure with the status and error source Grafana
+// reports, and a message that tells the user what to fix.
+type classifiedError struct {
+	class  errorClass
+	status backend.Status
+	source backend.ErrorSource
+	// field is the offending field of an invalid argument, when GA names it
+	field string
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	if e.class == errorClassPlugin {
+		return e.err.Error()
+	}
+	message := strings.ToUpper(string(e.class[:1])) + string(e.class[1:])
+	if e.field != "" {
+		message += fmt.Sprintf(" (field %q)", e.field)
+	}
+	message += ": " + errorDetail(e.err)
+	if hint := e.hint(); hint != "" {
+		message += ". " + hint
+	}
+	return message
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) hint() string {
+	switch e.class {
+	case errorClassAuth:
+		return "Check the service account key of the datasource"
+	case errorClassPermission:
+		if isServiceDisabled(e.err) {
+			return "Enable the Google Analytics Data and Admin APIs in the Google Cloud project of the service account"
+		}
+		if errors.Is(e.err, ErrNotAllowed) {
+			return ""
+		}
+		if errors.Is(e.err, errChangeHistory) {
+			return "Change history annotations need the analytics.edit scope, grant the service account Editor access to the Google Analytics account or use event annotations"
+		}
+		return "Grant the service account at least Viewer access to the property in Google Analytics"
+	case errorClassQuota:
+		return "Wait for the quota to refill, or raise the cache duration and lower the refresh rate"
+	case errorClassUnavailable:
+		return "Try again later"
+	}
+	return ""
+}
+
+// errorDetail is the message of err with the googleapi noise, e.g.
+// "googleapi: Error 400: ... , badRequest", reduced to GA's own message.
+func errorDetail(err error) string {
+	detail := err.Error()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Message != "" {
+		detail = strings.Replace(detail, apiErr.Error(), apiErr.Message, 1)
+	}
+	return strings.TrimSuffix(detail, ".")
+}
+
+func isServiceDisabled(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return strings.Contains(apiErr.Body, "SERVICE_DISABLED") || strings.Contains(apiErr.Message, "has not been used in project")
+}
+
+// invalidQuery marks err as a mistake in the query rather than in the plugin.
+func invalidQuery(err error) error {
+	return &classifiedError{class: errorClassInvalid, status: backend.StatusBadRequest, source: backend.ErrorSourceDownstream, err: err}
+}
+
+// invalidCredentials marks err as a problem with the datasource credentials.
+func invalidCredentials(err error) error {
+	return &classifiedError{class: errorClassAuth, status: backend.StatusUnauthorized, source: backend.ErrorSourceDownstream, err: err}
+}
+
+// classifyError sorts a query failure into auth failures, permission denied,
+// quota exhausted, invalid arguments and GA outages, all of which are
+// downstream errors. Anything else is a plugin error.
+func classifyError(err error) *classifiedError {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified
+	}
+	c := &classifiedError{class: errorClassPlugin, status: backend.StatusInternal, source: backend.ErrorSourcePlugin, err: err}
+
+	var apiErr *googleapi.Error
+	var retrieveErr *oauth2.RetrieveError
+	var netErr net.Error
+	isNetErr := errors.As(err, &netErr)
+	switch {
+	case errors.As(err, &apiErr):
+		c.source = backend.ErrorSourceDownstream
+		c.status = backend.Status(apiErr.Code)
+		switch {
+		case apiErr.Code == http.StatusTooManyRequests || isQuotaError(apiErr):
+			c.class, c.status = errorClassQuota, backend.StatusTooManyRequests
+		case apiErr.Code == http.StatusUnauthorized:
+			c.class = errorClassAuth
+		case apiErr.Code == http.StatusForbidden:
+			c.class = errorClassPermission
+		case apiErr.Code == http.StatusBadRequest || apiErr.Code == http.StatusNotFound:
+			c.class, c.field = errorClassInvalid, invalidField(apiErr)
+		case apiErr.Code == http.StatusGatewayTimeout:
+			c.class, c.status = errorClassUnavailable, backend.StatusTimeout
+		case apiErr.Code >= http.StatusInternalServerError:
+			c.class, c.status = errorClassUnavailable, backend.StatusBadGateway
+		default:
+			c.class = errorClassInvalid
+			if !c.status.IsValid() {
+				c.status = backend.StatusBadRequest
+			}
+		}
+	case errors.As(err, &retrieveErr), strings.Contains(err.Error(), "failed to retrieve google access token"):
+		c.class, c.status, c.source = errorClassAuth, backend.StatusUnauthorized, backend.ErrorSourceDownstream
+	case errors.Is(err, ErrNotAllowed):
+		c.class, c.status, c.source = errorClassPermission, backend.StatusForbidden, backend.ErrorSourceDownstream
+	case errors.Is(err, context.DeadlineExceeded), isNetErr && netErr.Timeout():
+		c.class, c.status, c.source = errorClassUnavailable, backend.StatusTimeout, backend.ErrorSourceDownstream
+	case errors.Is(err, context.Canceled):
+		c.source = backend.ErrorSourceDownstream
+	case isNetErr:
+		c.class, c.status, c.source = errorClassUnavailable, backend.StatusBadGateway, backend.ErrorSourceDownstream
+	}
+	return c
+}
+
+func isQuotaError(apiErr *googleapi.Error) bool {
+	for _, item := range apiErr.Errors {
+		for _, reason := range quotaReasons {
+			if item.Reason == reason {
+				return true
+			}
+		}
+	}
+	return strings.Contains(apiErr.Body, `"RESOURCE_EXHAUSTED"`)
+}
+
+// invalidField returns the field a 400 error is about, from the BadRequest
+// details when GA sends them and from the message otherwise.
+func invalidField(apiErr *googleapi.Error) string {
+	for _, detail := range apiErr.Details {
+		m, ok := detail.(map[string]interface{})
+		if !ok || !strings.HasSuffix(fmt.Sprint(m["@type"]), "google.rpc.BadRequest") {
+			continue
+		}
+		violations, _ := m["fieldViolations"].([]interface{})
+		for _, violation := range violations {
+			if v, ok := violation.(map[string]interface{}); ok {
+				if field, ok := v["field"].(string); ok && field != "" {
+					return field
+				}
+			}
+		}
+	}
+	if match := invalidFieldPattern.FindStringSubmatch(apiErr.Message); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// ErrorResponse turns a failed query into a DataResponse whose status and
+// error source let Grafana tell GA outages and bad queries from plugin bugs.
+func ErrorResponse(err error) backend.DataResponse {
+	c := classifyError(err)
+	return backend.DataResponse{
+		Frames:      data.Frames{},
+		Error:       c,
+		Status:      c.status,
+		ErrorSource: c.source,
+	}
+}
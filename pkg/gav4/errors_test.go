@@ -0,0 +1,90 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		class  errorClass
+		status backend.Status
+		source backend.ErrorSource
+	}{
+		{"unauthenticated", &googleapi.Error{Code: 401, Message: "Request had invalid authentication credentials."}, errorClassAuth, backend.StatusUnauthorized, backend.ErrorSourceDownstream},
+		{"token", &url.Error{Op: "Post", URL: "https://oauth2.googleapis.com/token", Err: &oauth2.RetrieveError{ErrorCode: "invalid_grant"}}, errorClassAuth, backend.StatusUnauthorized, backend.ErrorSourceDownstream},
+		{"forbidden", &googleapi.Error{Code: 403, Message: "User does not have sufficient permissions for this property."}, errorClassPermission, backend.StatusForbidden, backend.ErrorSourceDownstream},
+		{"allowlist", fmt.Errorf("property %q is %w", "properties/1", ErrNotAllowed), errorClassPermission, backend.StatusForbidden, backend.ErrorSourceDownstream},
+		{"quota", &googleapi.Error{Code: 429, Message: "Exhausted property tokens per day."}, errorClassQuota, backend.StatusTooManyRequests, backend.ErrorSourceDownstream},
+		{"rate limit", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, errorClassQuota, backend.StatusTooManyRequests, backend.ErrorSourceDownstream},
+		{"bad request", &googleapi.Error{Code: 400, Message: "Field sessionz is not a valid metric."}, errorClassInvalid, backend.StatusBadRequest, backend.ErrorSourceDownstream},
+		{"unavailable", &googleapi.Error{Code: 503, Message: "The service is currently unavailable."}, errorClassUnavailable, backend.StatusBadGateway, backend.ErrorSourceDownstream},
+		{"deadline", fmt.Errorf("runReport: %w", context.DeadlineExceeded), errorClassUnavailable, backend.StatusTimeout, backend.ErrorSourceDownstream},
+		{"query", invalidQuery(errors.New("required webpropertyid")), errorClassInvalid, backend.StatusBadRequest, backend.ErrorSourceDownstream},
+		{"plugin", errors.New("frame conversion failed"), errorClassPlugin, backend.StatusInternal, backend.ErrorSourcePlugin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := classifyError(tt.err)
+			if c.class != tt.class || c.status != tt.status || c.source != tt.source {
+				t.Errorf("classifyError = %s/%d/%s, want %s/%d/%s", c.class, c.status, c.source, tt.class, tt.status, tt.source)
+			}
+		})
+	}
+}
+
+func TestClassifyError_Message(t *testing.T) {
+	err := fmt.Errorf("properties/1: %w", &googleapi.Error{Code: 400, Message: "Field sessionz is not a valid metric."})
+	want := `Invalid argument (field "sessionz"): properties/1: Field sessionz is not a valid metric`
+	if got := classifyError(err).Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	details := &googleapi.Error{Code: 400, Message: "Invalid filter.", Details: []interface{}{
+		map[string]interface{}{
+			"@type":           "type.googleapis.com/google.rpc.BadRequest",
+			"fieldViolations": []interface{}{map[string]interface{}{"field": "dimensionFilter", "description": "bad"}},
+		},
+	}}
+	if field := classifyError(details).field; field != "dimensionFilter" {
+		t.Errorf("field = %q, want dimensionFilter", field)
+	}
+
+	disabled := &googleapi.Error{Code: 403, Message: "Google Analytics Data API has not been used in project 1 before or it is disabled."}
+	if got := classifyError(disabled).Error(); !strings.Contains(got, "Enable the Google Analytics Data and Admin APIs") {
+		t.Errorf("Error() = %q, want a hint to enable the APIs", got)
+	}
+
+	changeHistory := fmt.Errorf("%w: %w", errChangeHistory, &googleapi.Error{Code: 403, Message: "The caller does not have permission"})
+	if got := classifyError(changeHistory).Error(); !strings.Contains(got, "Editor access to the Google Analytics account") {
+		t.Errorf("Error() = %q, want a hint about the edit access change history needs", got)
+	}
+
+	plugin := errors.New("frame conversion failed")
+	if got := classifyError(plugin).Error(); got != plugin.Error() {
+		t.Errorf("plugin Error() = %q, want it unchanged", got)
+	}
+}
+
+func TestErrorResponse(t *testing.T) {
+	res := ErrorResponse(&googleapi.Error{Code: 429, Message: "Exhausted property tokens per hour."})
+	if res.Status != backend.StatusTooManyRequests || res.ErrorSource != backend.ErrorSourceDownstream {
+		t.Errorf("response status %d source %s", res.Status, res.ErrorSource)
+	}
+	if !strings.HasPrefix(res.Error.Error(), "Quota exhausted: Exhausted property tokens per hour. Wait for the quota") {
+		t.Errorf("Error = %q", res.Error)
+	}
+	if res.Frames == nil {
+		t.Error("Frames should be empty, not nil")
+	}
+}
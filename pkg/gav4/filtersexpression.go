@@ -0,0 +1,285 @@
+package gav4
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// uaField is the GA4 equivalent of a Universal Analytics field.
+type uaField struct {
+	name   string
+	metric bool
+}
+
+// uaFields maps Universal Analytics field names to GA4. Other ga: fields keep
+// their name without the prefix, which is right for most of them.
+var uaFields = map[string]uaField{
+	"ga:hostname":            {name: "hostName"},
+	"ga:source":              {name: "sessionSource"},
+	"ga:medium":              {name: "sessionMedium"},
+	"ga:sourceMedium":        {name: "sessionSourceMedium"},
+	"ga:campaign":            {name: "sessionCampaignName"},
+	"ga:channelGrouping":     {name: "sessionDefaultChannelGroup"},
+	"ga:landingPagePath":     {name: "landingPage"},
+	"ga:eventAction":         {name: "eventName"},
+	"ga:userType":            {name: "newVsReturning"},
+	"ga:mobileDeviceModel":   {name: "mobileModel"},
+	"ga:screenName":          {name: "unifiedScreenName"},
+	"ga:sessions":            {name: "sessions", metric: true},
+	"ga:users":               {name: "totalUsers", metric: true},
+	"ga:newUsers":            {name: "newUsers", metric: true},
+	"ga:pageviews":           {name: "screenPageViews", metric: true},
+	"ga:screenviews":         {name: "screenPageViews", metric: true},
+	"ga:pageviewsPerSession": {name: "screenPageViewsPerSession", metric: true},
+	"ga:totalEvents":         {name: "eventCount", metric: true},
+	"ga:bounceRate":          {name: "bounceRate", metric: true},
+	"ga:avgSessionDuration":  {name: "averageSessionDuration", metric: true},
+	"ga:transactions":        {name: "transactions", metric: true},
+	"ga:transactionRevenue":  {name: "purchaseRevenue", metric: true},
+	"ga:goalCompletionsAll":  {name: "keyEvents", metric: true},
+}
+
+// filterOperators in match order, two character operators first.
+var filterOperators = []string{"==", "!=", "=~", "!~", "=@", "!@", ">=", "<=", ">", "<"}
+
+// filtersExpressionError reports the 1-based character position of a problem
+// in a filtersExpression.
+type filtersExpressionError struct {
+	pos int
+	msg string
+}
+
+func (e *filtersExpressionError) Error() string {
+	return fmt.Sprintf("filtersExpression: position %d: %s", e.pos, e.msg)
+}
+
+// filterCondition is one field, operator and value of a filtersExpression.
+type filterCondition struct {
+	pos      int
+	field    uaField
+	operator string
+	value    string
+}
+
+// parseFiltersExpression translates a Universal Analytics filters string such
+// as "ga:country==US;ga:pagePath=~^/blog" into GA4 filters. ";" joins with AND
+// and "," with OR, OR binding tighter as it did in UA. \, and \; escape
+// separators in values. Metric conditions end up in the metric filter,
+// so a single OR group cannot mix dimensions and metrics.
+func parseFiltersExpression(expression string) (dimensionFilter *analyticsdata.FilterExpression, metricFilter *analyticsdata.FilterExpression, err error) {
+	var dimensionGroups, metricGroups []*analyticsdata.FilterExpression
+	for _, andPart := range splitUnescaped(expression, ';', 0) {
+		orParts := splitUnescaped(andPart.text, ',', andPart.pos)
+		var expressions []*analyticsdata.FilterExpression
+		metric := false
+		for i, orPart := range orParts {
+			condition, err := parseFilterCondition(orPart.text, orPart.pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			if i == 0 {
+				metric = condition.field.metric
+			} else if condition.field.metric != metric {
+				return nil, nil, &filtersExpressionError{orPart.pos + 1, "dimensions and metrics cannot be combined with OR"}
+			}
+			filter, err := condition.filterExpression()
+			if err != nil {
+				return nil, nil, err
+			}
+			expressions = append(expressions, filter)
+		}
+
+		group := expressions[0]
+		if len(expressions) > 1 {
+			group = &analyticsdata.FilterExpression{OrGroup: &analyticsdata.FilterExpressionList{Expressions: expressions}}
+		}
+		if metric {
+			metricGroups = append(metricGroups, group)
+		} else {
+			dimensionGroups = append(dimensionGroups, group)
+		}
+	}
+	return andExpressions(dimensionGroups), andExpressions(metricGroups), nil
+}
+
+func andExpressions(expressions []*analyticsdata.FilterExpression) *analyticsdata.FilterExpression {
+	switch len(expressions) {
+	case 0:
+		return nil
+	case 1:
+		return expressions[0]
+	default:
+		return &analyticsdata.FilterExpression{AndGroup: &analyticsdata.FilterExpressionList{Expressions: expressions}}
+	}
+}
+
+type expressionPart struct {
+	pos  int
+	text string
+}
+
+// splitUnescaped splits s at every sep that is not escaped with a backslash,
+// keeping the escapes for the value parser. offset is the position of s in
+// the whole expression.
+func splitUnescaped(s string, sep byte, offset int) []expressionPart {
+	var parts []expressionPart
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, expressionPart{offset + start, s[start:i]})
+			start = i + 1
+		}
+	}
+	return append(parts, expressionPart{offset + start, s[start:]})
+}
+
+func isFieldNameChar(c byte) bool {
+	return c == ':' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func parseFilterCondition(s string, offset int) (*filterCondition, error) {
+	i := 0
+	for i < len(s) && isFieldNameChar(s[i]) {
+		i++
+	}
+	if i == 0 {
+		return nil, &filtersExpressionError{offset + 1, "expected a field name"}
+	}
+	name := s[:i]
+	if i == len(s) {
+		return nil, &filtersExpressionError{offset + i + 1, fmt.Sprintf("expected an operator after %q", name)}
+	}
+
+	operator := ""
+	for _, op := range filterOperators {
+		if strings.HasPrefix(s[i:], op) {
+			operator = op
+			break
+		}
+	}
+	if operator == "" {
+		return nil, &filtersExpressionError{offset + i + 1, fmt.Sprintf("unknown operator %q", s[i:min(i+2, len(s))])}
+	}
+
+	field, ok := uaFields[name]
+	if !ok {
+		field = uaField{name: strings.TrimPrefix(name, "ga:")}
+		// Comparisons only make sense on metrics
+		field.metric = strings.ContainsAny(operator, "<>")
+	}
+	if field.metric && strings.ContainsAny(operator, "~@") {
+		return nil, &filtersExpressionError{offset + i + 1, fmt.Sprintf("operator %q is not supported for metric %q", operator, name)}
+	}
+	if !field.metric && strings.ContainsAny(operator, "<>") {
+		return nil, &filtersExpressionError{offset + i + 1, fmt.Sprintf("operator %q is not supported for dimension %q", operator, name)}
+	}
+
+	valuePos := offset + i + len(operator) + 1
+	return &filterCondition{
+		pos:      valuePos,
+		field:    field,
+		operator: operator,
+		value:    unescapeFilterValue(s[i+len(operator):]),
+	}, nil
+}
+
+// unescapeFilterValue removes the escapes of separators. Other backslashes
+// are kept so that regular expressions such as ^/a\.b$ pass through.
+func unescapeFilterValue(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\;`, ";").Replace(s)
+}
+
+func (c *filterCondition) filterExpression() (*analyticsdata.FilterExpression, error) {
+	if c.field.metric {
+		return c.numericFilterExpression()
+	}
+
+	filter := &analyticsdata.Filter{FieldName: c.field.name, StringFilter: &analyticsdata.StringFilter{Value: c.value}}
+	switch c.operator {
+	case "==", "!=":
+		filter.StringFilter.MatchType = "EXACT"
+		filter.StringFilter.CaseSensitive = true
+	case "=~", "!~":
+		filter.StringFilter.MatchType = "PARTIAL_REGEXP"
+	case "=@", "!@":
+		filter.StringFilter.MatchType = "CONTAINS"
+	}
+	expression := &analyticsdata.FilterExpression{Filter: filter}
+	if strings.HasPrefix(c.operator, "!") {
+		expression = &analyticsdata.FilterExpression{NotExpression: expression}
+	}
+	return expression, nil
+}
+
+func (c *filterCondition) numericFilterExpression() (*analyticsdata.FilterExpression, error) {
+	value, ok := parseNumericValue(c.value)
+	if !ok {
+		return nil, &filtersExpressionError{c.pos, fmt.Sprintf("%q is not a number", c.value)}
+	}
+
+	operations := map[string]string{
+		"==": "EQUAL",
+		"!=": "EQUAL",
+		">":  "GREATER_THAN",
+		">=": "GREATER_THAN_OR_EQUAL",
+		"<":  "LESS_THAN",
+		"<=": "LESS_THAN_OR_EQUAL",
+	}
+	expression := &analyticsdata.FilterExpression{
+		Filter: &analyticsdata.Filter{
+			FieldName:     c.field.name,
+			NumericFilter: &analyticsdata.NumericFilter{Operation: operations[c.operator], Value: value},
+		},
+	}
+	if c.operator == "!=" {
+		expression = &analyticsdata.FilterExpression{NotExpression: expression}
+	}
+	return expression, nil
+}
+
+// parseNumericValue reads an integer or a decimal number. Zero values are
+// sent explicitly, they would be dropped from the request otherwise.
+func parseNumericValue(s string) (*analyticsdata.NumericValue, bool) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &analyticsdata.NumericValue{Int64Value: i, ForceSendFields: []string{"Int64Value"}}, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return &analyticsdata.NumericValue{DoubleValue: f, ForceSendFields: []string{"DoubleValue"}}, true
+	}
+	return nil, false
+}
+
+// applyFiltersExpression adds the parsed filtersExpression of the query to
+// its dimension and metric filters.
+func applyFiltersExpression(queryModel *model.QueryModel) error {
+	if strings.TrimSpace(queryModel.FiltersExpression) == "" {
+		return nil
+	}
+	dimensionFilter, metricFilter, err := parseFiltersExpression(queryModel.FiltersExpression)
+	if err != nil {
+		return err
+	}
+	queryModel.DimensionFilter = andFilters(queryModel.DimensionFilter, dimensionFilter)
+	queryModel.MetricFilter = andFilters(queryModel.MetricFilter, metricFilter)
+	return nil
+}
+
+// andFilters joins two optional filters with AND.
+func andFilters(a, b *analyticsdata.FilterExpression) *analyticsdata.FilterExpression {
+	if !filterHasContent(b) {
+		return a
+	}
+	if !filterHasContent(a) {
+		return b
+	}
+	return &analyticsdata.FilterExpression{
+		AndGroup: &analyticsdata.FilterExpressionList{Expressions: []*analyticsdata.FilterExpression{a, b}},
+	}
+}
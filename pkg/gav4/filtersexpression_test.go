@@ -0,0 +1,69 @@
+package gav4
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+)
+
+func TestParseFiltersExpression(t *testing.T) {
+	dimensionFilter, metricFilter, err := parseFiltersExpression(`ga:country==US,ga:country==CA;ga:pagePath=~^/blog\.;ga:source!@goo\,gle;ga:sessions>10`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	and := dimensionFilter.AndGroup.Expressions
+	if len(and) != 3 {
+		t.Fatalf("expected 3 AND groups, got %d", len(and))
+	}
+	or := and[0].OrGroup.Expressions
+	if len(or) != 2 || or[1].Filter.StringFilter.Value != "CA" || or[1].Filter.StringFilter.MatchType != "EXACT" {
+		t.Errorf("OR group = %+v", and[0].OrGroup)
+	}
+	if f := and[1].Filter; f.FieldName != "pagePath" || f.StringFilter.MatchType != "PARTIAL_REGEXP" || f.StringFilter.Value != `^/blog\.` {
+		t.Errorf("regex filter = %+v %+v", f, f.StringFilter)
+	}
+	not := and[2].NotExpression
+	if not == nil || not.Filter.FieldName != "sessionSource" || not.Filter.StringFilter.MatchType != "CONTAINS" || not.Filter.StringFilter.Value != "goo,gle" {
+		t.Errorf("negated contains filter = %+v", and[2])
+	}
+
+	numeric := metricFilter.Filter
+	if numeric.FieldName != "sessions" || numeric.NumericFilter.Operation != "GREATER_THAN" || numeric.NumericFilter.Value.Int64Value != 10 {
+		t.Errorf("metric filter = %+v", numeric)
+	}
+}
+
+func TestParseFiltersExpression_Errors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ga:country", "position 11: expected an operator"},
+		{"ga:country==US;", "position 16: expected a field name"},
+		{"ga:country?US", "position 11: unknown operator"},
+		{"ga:sessions=~1", "position 12: operator \"=~\" is not supported for metric"},
+		{"ga:source>1", "position 10: operator \">\" is not supported for dimension"},
+		{"ga:sessions>ten", "position 13: \"ten\" is not a number"},
+		{"ga:country==US,ga:sessions>1", "position 16: dimensions and metrics cannot be combined"},
+	}
+	for _, tt := range tests {
+		_, _, err := parseFiltersExpression(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseFiltersExpression(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestApplyFiltersExpression(t *testing.T) {
+	qm := &model.QueryModel{FiltersExpression: "ga:deviceCategory==mobile"}
+	if err := applyFiltersExpression(qm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.DimensionFilter == nil || qm.DimensionFilter.Filter.FieldName != "deviceCategory" {
+		t.Errorf("DimensionFilter = %+v", qm.DimensionFilter)
+	}
+	if qm.MetricFilter != nil {
+		t.Errorf("MetricFilter = %+v", qm.MetricFilter)
+	}
+}
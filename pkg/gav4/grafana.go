@@ -1,6 +1,7 @@
 package gav4
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
@@ -10,8 +11,10 @@ import (
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
 	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/jinzhu/copier"
+	"go.opentelemetry.io/otel/trace"
 	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
 )
 
@@ -113,6 +116,88 @@ func transformReportToDataFramesTableMode(report *analyticsdata.RunReportRespons
 	return frames, nil
 }
 
+// realtimeRangeDimension is the dimension GA adds to every realtime row when
+// the request has more than one minute range; its value is the range name.
+const realtimeRangeDimension = "dateRange"
+
+// transformRealtimeReportToDataFrames returns one table frame per minute range,
+// named after the range, or a single table frame when only one range was sent.
+func transformRealtimeReportToDataFrames(report *analyticsdata.RunReportResponse, refId string, timezone string) ([]*data.Frame, error) {
+	names, reports := splitReportByMinuteRange(report)
+	if len(names) == 0 {
+		return transformReportToDataFramesTableMode(report, refId, timezone)
+	}
+
+	var frames = make([]*data.Frame, 0, len(names))
+	for _, name := range names {
+		rangeFrames, err := transformReportToDataFramesTableMode(reports[name], refId, timezone)
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range rangeFrames {
+			frame.Name = name
+			for _, field := range frame.Fields {
+				if field.Type() == data.FieldTypeNullableFloat64 {
+					field.Config.DisplayName = name + " " + field.Name
+				}
+			}
+		}
+		frames = append(frames, rangeFrames...)
+	}
+	return frames, nil
+}
+
+// splitReportByMinuteRange splits a multi-range realtime report into one report
+// per range with the range dimension removed. Names are returned in order of
+// first appearance; nil means the report has no range dimension.
+func splitReportByMinuteRange(report *analyticsdata.RunReportResponse) ([]string, map[string]*analyticsdata.RunReportResponse) {
+	rangeIndex := -1
+	for i, header := range report.DimensionHeaders {
+		if header.Name == realtimeRangeDimension {
+			rangeIndex = i
+			break
+		}
+	}
+	if rangeIndex < 0 {
+		return nil, nil
+	}
+
+	dimensionHeaders := make([]*analyticsdata.DimensionHeader, 0, len(report.DimensionHeaders)-1)
+	dimensionHeaders = append(dimensionHeaders, report.DimensionHeaders[:rangeIndex]...)
+	dimensionHeaders = append(dimensionHeaders, report.DimensionHeaders[rangeIndex+1:]...)
+
+	var names []string
+	reports := make(map[string]*analyticsdata.RunReportResponse)
+	for _, row := range report.Rows {
+		if len(row.DimensionValues) <= rangeIndex {
+			continue
+		}
+		name := row.DimensionValues[rangeIndex].Value
+		rangeReport, ok := reports[name]
+		if !ok {
+			metricHeaders := make([]*analyticsdata.MetricHeader, len(report.MetricHeaders))
+			copy(metricHeaders, report.MetricHeaders)
+			rangeReport = &analyticsdata.RunReportResponse{
+				DimensionHeaders: dimensionHeaders,
+				MetricHeaders:    metricHeaders,
+			}
+			reports[name] = rangeReport
+			names = append(names, name)
+		}
+		dimensionValues := make([]*analyticsdata.DimensionValue, 0, len(row.DimensionValues)-1)
+		dimensionValues = append(dimensionValues, row.DimensionValues[:rangeIndex]...)
+		dimensionValues = append(dimensionValues, row.DimensionValues[rangeIndex+1:]...)
+		rangeReport.Rows = append(rangeReport.Rows, &analyticsdata.Row{
+			DimensionValues: dimensionValues,
+			MetricValues:    row.MetricValues,
+		})
+	}
+	for _, rangeReport := range reports {
+		rangeReport.RowCount = int64(len(rangeReport.Rows))
+	}
+	return names, reports
+}
+
 func transformReportToDataFrames(report *analyticsdata.RunReportResponse, refId string, timezone string, from, to time.Time) ([]*data.Frame, error) {
 
 	timeDimension := analyticsdata.MetricHeader{
@@ -218,23 +303,32 @@ func transformReportToDataFrames(report *analyticsdata.RunReportResponse, refId
 	return frames, nil
 }
 
-func transformReportsResponseToDataFrames(reportsResponse *analyticsdata.RunReportResponse, refId string, timezone string, mode model.QueryMode, from, to time.Time) (*data.Frames, error) {
+func transformReportsResponseToDataFrames(ctx context.Context, reportsResponse *analyticsdata.RunReportResponse, refId string, timezone string, mode model.QueryMode, from, to time.Time) (*data.Frames, error) {
+	_, span := tracing.DefaultTracer().Start(ctx, "transformReportsResponseToDataFrames", trace.WithAttributes(
+		attributeMode.String(string(mode)),
+		attributeRows.Int(len(reportsResponse.Rows)),
+	))
+	defer span.End()
+
 	var frames = make(data.Frames, 0)
 	var frame []*data.Frame
 	var err error
 	switch mode {
-	case model.TABLE, model.REALTIME:
+	case model.REALTIME:
+		frame, err = transformRealtimeReportToDataFrames(reportsResponse, refId, timezone)
+	case model.TABLE:
 		frame, err = transformReportToDataFramesTableMode(reportsResponse, refId, timezone)
 	default:
 		frame, err = transformReportToDataFrames(reportsResponse, refId, timezone, from, to)
 	}
 	if err != nil {
-		return nil, err
+		return nil, tracing.Error(span, err)
 	}
 
 	frames = append(frames, frame...)
 	// }
 
+	span.SetAttributes(attributeFrames.Int(len(frames)))
 	return &frames, nil
 }
 
@@ -277,6 +371,10 @@ var numberConverter = data.FieldConverter{
 			return nil, fmt.Errorf("expected type string, but got %T", i)
 		}
 
+		// empty values are nulls, e.g. a calculated field divided by zero
+		if value == "" {
+			return (*float64)(nil), nil
+		}
 		num, err := strconv.ParseFloat(value, 64)
 		if err != nil {
 			return nil, fmt.Errorf("expected type string, but got %T", value)
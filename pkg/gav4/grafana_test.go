@@ -144,3 +144,64 @@ func TestTransformReportToDataFrames_NoRangeKeepsAllRows(t *testing.T) {
 		t.Errorf("expected both rows kept without range filter, got %d non-zero", nonZero)
 	}
 }
+
+func TestTransformRealtimeReportToDataFrames_SplitsMinuteRanges(t *testing.T) {
+	report := &analyticsdata.RunReportResponse{
+		DimensionHeaders: []*analyticsdata.DimensionHeader{{Name: "country"}, {Name: "dateRange"}},
+		MetricHeaders:    []*analyticsdata.MetricHeader{{Name: "activeUsers", Type: "TYPE_INTEGER"}},
+		Rows: []*analyticsdata.Row{
+			{
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "US"}, {Value: "recent"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "5"}},
+			},
+			{
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "US"}, {Value: "baseline"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "20"}},
+			},
+			{
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "CA"}, {Value: "recent"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "1"}},
+			},
+		},
+	}
+
+	frames, err := transformRealtimeReportToDataFrames(report, "A", "UTC")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("expected one frame per minute range, got %d", len(frames))
+	}
+	if frames[0].Name != "recent" || frames[1].Name != "baseline" {
+		t.Errorf("frame names = %q, %q", frames[0].Name, frames[1].Name)
+	}
+	if frames[0].Rows() != 2 || frames[1].Rows() != 1 {
+		t.Errorf("rows = %d, %d, want 2, 1", frames[0].Rows(), frames[1].Rows())
+	}
+	for _, field := range frames[0].Fields {
+		if field.Name == "dateRange" {
+			t.Errorf("range dimension should be removed from the frame")
+		}
+	}
+}
+
+func TestTransformRealtimeReportToDataFrames_SingleRange(t *testing.T) {
+	report := &analyticsdata.RunReportResponse{
+		DimensionHeaders: []*analyticsdata.DimensionHeader{{Name: "country"}},
+		MetricHeaders:    []*analyticsdata.MetricHeader{{Name: "activeUsers", Type: "TYPE_INTEGER"}},
+		Rows: []*analyticsdata.Row{
+			{
+				DimensionValues: []*analyticsdata.DimensionValue{{Value: "US"}},
+				MetricValues:    []*analyticsdata.MetricValue{{Value: "5"}},
+			},
+		},
+	}
+
+	frames, err := transformRealtimeReportToDataFrames(report, "A", "UTC")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(frames) != 1 || frames[0].Name != "A" {
+		t.Fatalf("expected a single table frame named after refId, got %v", frames)
+	}
+}
@@ -0,0 +1,178 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/auth"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+const (
+	healthStepOK      = "ok"
+	healthStepError   = "error"
+	healthStepSkipped = "skipped"
+)
+
+// healthStep is the outcome of one check of CheckHealth, reported in
+// CheckHealthResult.JSONDetails.
+type healthStep struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type healthCheck struct {
+	steps []healthStep
+}
+
+// run records the outcome of check and reports whether it passed.
+func (h *healthCheck) run(name string, check func() (string, error)) bool {
+	message, err := check()
+	if err != nil {
+		log.DefaultLogger.Error("CheckHealth: "+name, "error", err.Error())
+		h.steps = append(h.steps, healthStep{Name: name, Status: healthStepError, Message: classifyError(err).Error()})
+		return false
+	}
+	h.steps = append(h.steps, healthStep{Name: name, Status: healthStepOK, Message: message})
+	return true
+}
+
+func (h *healthCheck) skip(reason string, names ...string) {
+	for _, name := range names {
+		h.steps = append(h.steps, healthStep{Name: name, Status: healthStepSkipped, Message: reason})
+	}
+}
+
+// result is an error naming the first failed step, or success with summary.
+// Every step goes into JSONDetails, and into the verboseMessage Grafana shows
+// under the result.
+func (h *healthCheck) result(summary string) *backend.CheckHealthResult {
+	res := &backend.CheckHealthResult{Status: backend.HealthStatusOk, Message: "Success"}
+	if summary != "" {
+		res.Message += ": " + summary
+	}
+	lines := make([]string, len(h.steps))
+	for i, step := range h.steps {
+		lines[i] = fmt.Sprintf("%s: %s - %s", step.Name, step.Status, step.Message)
+		if step.Status == healthStepError && res.Status == backend.HealthStatusOk {
+			res.Status = backend.HealthStatusError
+			res.Message = fmt.Sprintf("%s failed: %s", step.Name, step.Message)
+		}
+	}
+	details, err := json.Marshal(map[string]interface{}{
+		"steps":          h.steps,
+		"verboseMessage": strings.Join(lines, "\n"),
+	})
+	if err == nil {
+		res.JSONDetails = details
+	}
+	return res
+}
+
+// CheckHealth walks the datasource setup step by step: the credentials are
+// parsed, a token is minted, the Admin and Data APIs are called, the
+// accessible accounts and properties are counted and a test report is run
+// against the configured health check property or the first property found.
+// A step that depends on a failed one is skipped.
+func (ga *GoogleAnalytics) CheckHealth(ctx context.Context, config *setting.DatasourceSecretSettings) (*backend.CheckHealthResult, error) {
+	h := &healthCheck{}
+
+	var resolved *auth.Resolved
+	if !h.run("Credentials", func() (string, error) {
+		var err error
+		resolved, err = auth.Resolve(config)
+		if err != nil {
+			return "", invalidCredentials(err)
+		}
+		return fmt.Sprintf("service account %s", resolved.ClientEmail), nil
+	}) {
+		h.skip("credentials are invalid", "Token", "Admin API", "Data API", "Accounts", "Test property")
+		return h.result(""), nil
+	}
+
+	if !h.run("Token", func() (string, error) {
+		if err := auth.FetchToken(ctx, resolved, []string{analyticsdata.AnalyticsReadonlyScope}); err != nil {
+			return "", invalidCredentials(err)
+		}
+		return "access token minted", nil
+	}) {
+		h.skip("no access token", "Admin API", "Data API", "Accounts", "Test property")
+		return h.result(""), nil
+	}
+
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	adminEnabled := h.run("Admin API", func() (string, error) {
+		_, err := client.analyticsadmin.AccountSummaries.List().PageSize(1).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		return "enabled", nil
+	})
+
+	h.run("Data API", func() (string, error) {
+		metadata, err := client.getMetadata(ctx, "")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("enabled, %d dimensions and %d metrics", len(metadata.Dimensions), len(metadata.Metrics)), nil
+	})
+
+	var accounts []*model.AccountSummary
+	var summary string
+	if adminEnabled {
+		h.run("Accounts", func() (string, error) {
+			var err error
+			accounts, err = ga.GetAccountSummaries(ctx, config)
+			if err != nil {
+				return "", err
+			}
+			properties := 0
+			for _, account := range accounts {
+				properties += len(account.PropertySummaries)
+			}
+			if properties == 0 {
+				return "", fmt.Errorf("no property is accessible, add the service account %s to a Google Analytics property", resolved.ClientEmail)
+			}
+			summary = fmt.Sprintf("%d accounts and %d properties accessible", len(accounts), properties)
+			return summary, nil
+		})
+	} else {
+		h.skip("the Admin API is unavailable", "Accounts")
+	}
+
+	propertyId := config.HealthCheckPropertyID
+	if propertyId == "" {
+		for _, account := range accounts {
+			if len(account.PropertySummaries) > 0 {
+				propertyId = account.PropertySummaries[0].Property
+				break
+			}
+		}
+	}
+	if propertyId == "" {
+		h.skip("no property to run a test report against", "Test property")
+		return h.result(summary), nil
+	}
+	h.run("Test property", func() (string, error) {
+		if err := ga.checkProperty(ctx, config, propertyId); err != nil {
+			return "", err
+		}
+		report, err := client.getReport(ctx, model.QueryModel{WebPropertyID: propertyId, StartDate: "yesterday", EndDate: "today", RefID: "a", Metrics: []string{"active1DayUsers"}, Dimensions: []string{"date"}, PageSize: GaReportMaxResult, Timezone: "UTC"})
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s returned %d rows", propertyId, len(report.Rows)), nil
+	})
+	return h.result(summary), nil
+}
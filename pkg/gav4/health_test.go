@@ -0,0 +1,73 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func healthSteps(t *testing.T, res *backend.CheckHealthResult) []healthStep {
+	t.Helper()
+	var details struct {
+		Steps          []healthStep `json:"steps"`
+		VerboseMessage string       `json:"verboseMessage"`
+	}
+	if err := json.Unmarshal(res.JSONDetails, &details); err != nil {
+		t.Fatalf("JSONDetails: %v", err)
+	}
+	if len(strings.Split(details.VerboseMessage, "\n")) != len(details.Steps) {
+		t.Errorf("verboseMessage = %q", details.VerboseMessage)
+	}
+	return details.Steps
+}
+
+func TestCheckHealth_Credentials(t *testing.T) {
+	ga := &GoogleAnalytics{}
+	res, err := ga.CheckHealth(context.Background(), &setting.DatasourceSecretSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Status != backend.HealthStatusError || !strings.HasPrefix(res.Message, "Credentials failed: Authentication failed") {
+		t.Errorf("result = %v %q", res.Status, res.Message)
+	}
+	steps := healthSteps(t, res)
+	if len(steps) != 6 || steps[0].Status != healthStepError {
+		t.Fatalf("steps = %+v", steps)
+	}
+	for _, step := range steps[1:] {
+		if step.Status != healthStepSkipped {
+			t.Errorf("step %s = %s, want skipped", step.Name, step.Status)
+		}
+	}
+}
+
+func TestCheckHealth_Token(t *testing.T) {
+	ga := &GoogleAnalytics{}
+	config := &setting.DatasourceSecretSettings{ClientEmail: "sa@example.iam.gserviceaccount.com", TokenURI: "https://oauth2.googleapis.com/token", PrivateKey: "not a key"}
+	res, err := ga.CheckHealth(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	steps := healthSteps(t, res)
+	if steps[0].Status != healthStepOK || steps[1].Name != "Token" || steps[1].Status != healthStepError {
+		t.Fatalf("steps = %+v", steps)
+	}
+	if !strings.HasPrefix(res.Message, "Token failed: Authentication failed") {
+		t.Errorf("message = %q", res.Message)
+	}
+	if steps[2].Status != healthStepSkipped {
+		t.Errorf("Admin API = %s, want skipped", steps[2].Status)
+	}
+}
+
+func TestHealthCheckResult(t *testing.T) {
+	h := &healthCheck{}
+	h.steps = []healthStep{{Name: "Credentials", Status: healthStepOK}, {Name: "Accounts", Status: healthStepOK}}
+	if res := h.result("1 accounts and 2 properties accessible"); res.Status != backend.HealthStatusOk || res.Message != "Success: 1 accounts and 2 properties accessible" {
+		t.Errorf("result = %v %q", res.Status, res.Message)
+	}
+}
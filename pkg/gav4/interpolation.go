@@ -0,0 +1,285 @@
+package gav4
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// variablePattern matches the template variable syntaxes Grafana supports:
+// $var, [[var]], [[var:format]], ${var} and ${var:format}.
+var variablePattern = regexp.MustCompile(`\$(\w+)|\[\[(\w+)(?::(\w+))?\]\]|\$\{(\w+)(?::([^}]+))?\}`)
+
+// templateVariables maps a variable name to its current values.
+type templateVariables map[string][]string
+
+type variableReference struct {
+	start, end   int
+	name, format string
+}
+
+func findVariables(s string) []variableReference {
+	var refs []variableReference
+	for _, m := range variablePattern.FindAllStringSubmatchIndex(s, -1) {
+		ref := variableReference{start: m[0], end: m[1]}
+		switch {
+		case m[2] >= 0:
+			ref.name = s[m[2]:m[3]]
+		case m[4] >= 0:
+			ref.name = s[m[4]:m[5]]
+			if m[6] >= 0 {
+				ref.format = s[m[6]:m[7]]
+			}
+		default:
+			ref.name = s[m[8]:m[9]]
+			if m[10] >= 0 {
+				ref.format = s[m[10]:m[11]]
+			}
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// formatVariable joins the values of a variable the way Grafana's format
+// options do. Unknown formats fall back to csv.
+func formatVariable(values []string, format string) string {
+	switch format {
+	case "pipe":
+		return strings.Join(values, "|")
+	case "regex":
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = regexp.QuoteMeta(v)
+		}
+		if len(quoted) == 1 {
+			return quoted[0]
+		}
+		return "(" + strings.Join(quoted, "|") + ")"
+	case "singlequote":
+		return "'" + strings.Join(values, "','") + "'"
+	case "doublequote":
+		return `"` + strings.Join(values, `","`) + `"`
+	default:
+		return strings.Join(values, ",")
+	}
+}
+
+// replace substitutes every known variable in s, joining multiple values.
+// Unknown variables are left untouched.
+func (vars templateVariables) replace(s string) string {
+	refs := findVariables(s)
+	if len(refs) == 0 {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		b.WriteString(s[last:ref.start])
+		if values, ok := vars[ref.name]; ok {
+			b.WriteString(formatVariable(values, ref.format))
+		} else {
+			b.WriteString(s[ref.start:ref.end])
+		}
+		last = ref.end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// expand returns every string s takes for the current variable values. A
+// multi-value variable without an explicit format yields one result per
+// value; several of them yield their combinations.
+func (vars templateVariables) expand(s string) []string {
+	refs := findVariables(s)
+	if len(refs) == 0 {
+		return []string{s}
+	}
+	results := []string{""}
+	last := 0
+	for _, ref := range refs {
+		literal := s[last:ref.start]
+		last = ref.end
+
+		var options []string
+		values, ok := vars[ref.name]
+		switch {
+		case !ok:
+			options = []string{s[ref.start:ref.end]}
+		case ref.format != "" || len(values) <= 1:
+			options = []string{formatVariable(values, ref.format)}
+		default:
+			options = values
+		}
+
+		next := make([]string, 0, len(results)*len(options))
+		for _, prefix := range results {
+			for _, option := range options {
+				next = append(next, prefix+literal+option)
+			}
+		}
+		results = next
+	}
+	for i := range results {
+		results[i] += s[last:]
+	}
+	return results
+}
+
+func (vars templateVariables) expandAll(values []string) []string {
+	var out []string
+	for _, v := range values {
+		out = append(out, vars.expand(v)...)
+	}
+	return out
+}
+
+// interpolateQueryModel applies the query's template variables to metrics,
+// dimensions, the property and filter values, so that queries evaluated
+// without a browser (alerting, public dashboards) behave like panel queries.
+// Variables the browser did not send fall back to the values saved with the
+// query; a declared variable left without a value fails the query rather than
+// being sent to GA literally. Any other $ is literal text, e.g. $10 or ^/a$b.
+func interpolateQueryModel(queryModel *model.QueryModel) error {
+	vars := templateVariables{}
+	for name, values := range queryModel.VariableDefaults {
+		vars[name] = values
+	}
+	for name, values := range queryModel.Variables {
+		vars[name] = values
+	}
+	if name, ok := vars.unresolved(queryStrings(queryModel)); ok {
+		return fmt.Errorf("unresolved template variable $%s, open and save the panel on a dashboard where it has a value", name)
+	}
+	if len(vars) == 0 {
+		return nil
+	}
+
+	// A multi-value property variable turns the query into a multi-property query
+	queryModel.WebPropertyIDs = vars.expandAll(queryModel.WebPropertyIDs)
+	properties := vars.expand(queryModel.WebPropertyID)
+	queryModel.WebPropertyID = properties[0]
+	queryModel.WebPropertyIDs = append(properties[1:], queryModel.WebPropertyIDs...)
+	queryModel.AccountID = vars.replace(queryModel.AccountID)
+	queryModel.TimeDimension = vars.replace(queryModel.TimeDimension)
+	queryModel.Metrics = vars.expandAll(queryModel.Metrics)
+	queryModel.Dimensions = vars.expandAll(queryModel.Dimensions)
+	queryModel.FiltersExpression = vars.replace(queryModel.FiltersExpression)
+	queryModel.RawQuery = vars.replace(queryModel.RawQuery)
+	for i, field := range queryModel.CalculatedFields {
+		queryModel.CalculatedFields[i].Expression = vars.replace(field.Expression)
+	}
+	interpolateFilterExpression(vars, queryModel.DimensionFilter)
+	interpolateFilterExpression(vars, queryModel.MetricFilter)
+	return nil
+}
+
+// unresolved returns the first declared variable referenced in values that
+// has no value. References to names the dashboard does not declare are not
+// variables and stay as they are.
+func (vars templateVariables) unresolved(values []string) (string, bool) {
+	for _, v := range values {
+		for _, ref := range findVariables(v) {
+			if values, ok := vars[ref.name]; ok && len(values) == 0 {
+				return ref.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// queryStrings lists every field of the query that may reference a variable
+func queryStrings(queryModel *model.QueryModel) []string {
+	values := []string{queryModel.WebPropertyID, queryModel.AccountID, queryModel.TimeDimension, queryModel.FiltersExpression, queryModel.RawQuery}
+	values = append(values, queryModel.WebPropertyIDs...)
+	values = append(values, queryModel.Metrics...)
+	values = append(values, queryModel.Dimensions...)
+	for _, field := range queryModel.CalculatedFields {
+		values = append(values, field.Expression)
+	}
+	values = filterStrings(values, queryModel.DimensionFilter)
+	return filterStrings(values, queryModel.MetricFilter)
+}
+
+func filterStrings(values []string, expression *analyticsdata.FilterExpression) []string {
+	if expression == nil {
+		return values
+	}
+	if filter := expression.Filter; filter != nil {
+		values = append(values, filter.FieldName)
+		if filter.StringFilter != nil {
+			values = append(values, filter.StringFilter.Value)
+		}
+		if filter.InListFilter != nil {
+			values = append(values, filter.InListFilter.Values...)
+		}
+	}
+	if expression.AndGroup != nil {
+		for _, e := range expression.AndGroup.Expressions {
+			values = filterStrings(values, e)
+		}
+	}
+	if expression.OrGroup != nil {
+		for _, e := range expression.OrGroup.Expressions {
+			values = filterStrings(values, e)
+		}
+	}
+	return filterStrings(values, expression.NotExpression)
+}
+
+// interpolateFilterExpression mirrors interpolateFilterExpression in the
+// frontend. A string filter on a multi-value variable becomes an InListFilter
+// for exact matches, or an OR group of one filter per value otherwise.
+func interpolateFilterExpression(vars templateVariables, expression *analyticsdata.FilterExpression) {
+	if expression == nil {
+		return
+	}
+	if filter := expression.Filter; filter != nil {
+		filter.FieldName = vars.replace(filter.FieldName)
+		if filter.InListFilter != nil {
+			filter.InListFilter.Values = vars.expandAll(filter.InListFilter.Values)
+		}
+		if filter.StringFilter != nil {
+			values := vars.expand(filter.StringFilter.Value)
+			switch {
+			case len(values) == 1:
+				filter.StringFilter.Value = values[0]
+			case filter.StringFilter.MatchType == "EXACT":
+				filter.InListFilter = &analyticsdata.InListFilter{
+					Values:        values,
+					CaseSensitive: filter.StringFilter.CaseSensitive,
+				}
+				filter.StringFilter = nil
+			default:
+				expressions := make([]*analyticsdata.FilterExpression, len(values))
+				for i, value := range values {
+					stringFilter := *filter.StringFilter
+					stringFilter.Value = value
+					expressions[i] = &analyticsdata.FilterExpression{
+						Filter: &analyticsdata.Filter{
+							FieldName:    filter.FieldName,
+							StringFilter: &stringFilter,
+						},
+					}
+				}
+				expression.Filter = nil
+				expression.OrGroup = &analyticsdata.FilterExpressionList{Expressions: expressions}
+				return
+			}
+		}
+	}
+	if expression.AndGroup != nil {
+		for _, e := range expression.AndGroup.Expressions {
+			interpolateFilterExpression(vars, e)
+		}
+	}
+	if expression.OrGroup != nil {
+		for _, e := range expression.OrGroup.Expressions {
+			interpolateFilterExpression(vars, e)
+		}
+	}
+	interpolateFilterExpression(vars, expression.NotExpression)
+}
@@ -0,0 +1,185 @@
+package gav4
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestTemplateVariables_Replace(t *testing.T) {
+	vars := templateVariables{
+		"property": {"properties/1"},
+		"country":  {"US", "C.A"},
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"$property", "properties/1"},
+		{"${property}", "properties/1"},
+		{"[[property]]", "properties/1"},
+		{"$country", "US,C.A"},
+		{"${country:pipe}", "US|C.A"},
+		{"^${country:regex}$", `^(US|C\.A)$`},
+		{"$unknown", "$unknown"},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		if got := vars.replace(tt.in); got != tt.want {
+			t.Errorf("replace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateVariables_Expand(t *testing.T) {
+	vars := templateVariables{
+		"metric": {"sessions", "activeUsers"},
+		"suffix": {"a", "b"},
+	}
+	if got := vars.expand("$metric"); !reflect.DeepEqual(got, []string{"sessions", "activeUsers"}) {
+		t.Errorf("expand($metric) = %v", got)
+	}
+	if got := vars.expand("x-${suffix}-$suffix"); !reflect.DeepEqual(got, []string{"x-a-a", "x-a-b", "x-b-a", "x-b-b"}) {
+		t.Errorf("expand combinations = %v", got)
+	}
+	if got := vars.expand("${metric:csv}"); !reflect.DeepEqual(got, []string{"sessions,activeUsers"}) {
+		t.Errorf("explicit format must not expand, got %v", got)
+	}
+}
+
+func TestInterpolateQueryModel(t *testing.T) {
+	qm := &model.QueryModel{
+		WebPropertyID: "$property",
+		Metrics:       []string{"$metric", "eventCount"},
+		Dimensions:    []string{"country"},
+		DimensionFilter: &analyticsdata.FilterExpression{
+			AndGroup: &analyticsdata.FilterExpressionList{
+				Expressions: []*analyticsdata.FilterExpression{
+					{Filter: &analyticsdata.Filter{
+						FieldName:    "country",
+						StringFilter: &analyticsdata.StringFilter{MatchType: "EXACT", Value: "$country"},
+					}},
+					{Filter: &analyticsdata.Filter{
+						FieldName:    "pagePath",
+						StringFilter: &analyticsdata.StringFilter{MatchType: "BEGINS_WITH", Value: "$path"},
+					}},
+					{Filter: &analyticsdata.Filter{
+						FieldName:    "city",
+						InListFilter: &analyticsdata.InListFilter{Values: []string{"$city", "Paris"}},
+					}},
+				},
+			},
+		},
+		Variables: map[string][]string{
+			"property": {"properties/1"},
+			"metric":   {"sessions", "activeUsers"},
+			"country":  {"US", "CA"},
+			"path":     {"/blog", "/docs"},
+			"city":     {"Seoul"},
+		},
+	}
+
+	if err := interpolateQueryModel(qm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.WebPropertyID != "properties/1" {
+		t.Errorf("WebPropertyID = %q", qm.WebPropertyID)
+	}
+	if !reflect.DeepEqual(qm.Metrics, []string{"sessions", "activeUsers", "eventCount"}) {
+		t.Errorf("Metrics = %v", qm.Metrics)
+	}
+
+	expressions := qm.DimensionFilter.AndGroup.Expressions
+	exact := expressions[0].Filter
+	if exact.StringFilter != nil || exact.InListFilter == nil || !reflect.DeepEqual(exact.InListFilter.Values, []string{"US", "CA"}) {
+		t.Errorf("exact multi-value filter should become an in-list filter, got %+v", exact)
+	}
+	prefix := expressions[1]
+	if prefix.Filter != nil || prefix.OrGroup == nil || len(prefix.OrGroup.Expressions) != 2 {
+		t.Fatalf("begins-with multi-value filter should become an OR group, got %+v", prefix)
+	}
+	if v := prefix.OrGroup.Expressions[1].Filter.StringFilter.Value; v != "/docs" {
+		t.Errorf("second OR value = %q, want /docs", v)
+	}
+	if got := expressions[2].Filter.InListFilter.Values; !reflect.DeepEqual(got, []string{"Seoul", "Paris"}) {
+		t.Errorf("in-list values = %v", got)
+	}
+}
+
+func TestInterpolateQueryModel_NoVariables(t *testing.T) {
+	qm := &model.QueryModel{WebPropertyID: "properties/1", Metrics: []string{"sessions"}}
+	if err := interpolateQueryModel(qm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.WebPropertyID != "properties/1" || qm.Metrics[0] != "sessions" {
+		t.Errorf("query without variables must be left untouched, got %+v", qm)
+	}
+}
+
+func TestInterpolateQueryModel_Defaults(t *testing.T) {
+	qm := &model.QueryModel{
+		WebPropertyID:    "$property",
+		Metrics:          []string{"$metric"},
+		Variables:        map[string][]string{"metric": {"sessions"}},
+		VariableDefaults: map[string][]string{"property": {"properties/1"}, "metric": {"eventCount"}},
+	}
+	if err := interpolateQueryModel(qm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.WebPropertyID != "properties/1" || qm.Metrics[0] != "sessions" {
+		t.Errorf("saved defaults must fill in variables the browser did not send, got %+v", qm)
+	}
+}
+
+func TestInterpolateQueryModel_Unresolved(t *testing.T) {
+	qm := &model.QueryModel{
+		WebPropertyID: "properties/1",
+		Metrics:       []string{"sessions"},
+		DimensionFilter: &analyticsdata.FilterExpression{
+			Filter: &analyticsdata.Filter{
+				FieldName:    "country",
+				StringFilter: &analyticsdata.StringFilter{MatchType: "EXACT", Value: "${country}"},
+			},
+		},
+		VariableDefaults: map[string][]string{"country": {}},
+	}
+	err := interpolateQueryModel(qm)
+	if err == nil || !strings.Contains(err.Error(), "unresolved template variable $country") {
+		t.Errorf("err = %v, want an unresolved variable error", err)
+	}
+}
+
+func TestInterpolateQueryModel_LiteralDollar(t *testing.T) {
+	filter := func(matchType, value string) *analyticsdata.FilterExpression {
+		return &analyticsdata.FilterExpression{
+			Filter: &analyticsdata.Filter{
+				FieldName:    "pagePath",
+				StringFilter: &analyticsdata.StringFilter{MatchType: matchType, Value: value},
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		qm        *model.QueryModel
+		variables map[string][]string
+		want      string
+	}{
+		{"amount", &model.QueryModel{DimensionFilter: filter("EXACT", "$10")}, nil, "$10"},
+		{"regex", &model.QueryModel{DimensionFilter: filter("FULL_REGEXP", "^/a$b")}, nil, "^/a$b"},
+		{"undeclared", &model.QueryModel{DimensionFilter: filter("CONTAINS", "$price")}, map[string][]string{"country": {"US"}}, "$price"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.qm.WebPropertyID = "properties/1"
+			tt.qm.Variables = tt.variables
+			if err := interpolateQueryModel(tt.qm); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := tt.qm.DimensionFilter.Filter.StringFilter.Value; got != tt.want {
+				t.Errorf("value = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
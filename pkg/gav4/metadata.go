@@ -0,0 +1,168 @@
+package gav4
+
+import (
+	"context"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	analyticsadminalpha "google.golang.org/api/analyticsadmin/v1alpha"
+	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// customDefinitions holds the Admin API definitions of a property keyed by
+// the apiName the Data API reports them under.
+type customDefinitions struct {
+	dimensions map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomDimension
+	metrics    map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomMetric
+	calculated map[string]*analyticsadminalpha.GoogleAnalyticsAdminV1alphaCalculatedMetric
+}
+
+// customDefinitionAPIName returns the Data API name of a custom dimension or
+// metric, e.g. customUser:plan for a USER scoped "plan" parameter.
+func customDefinitionAPIName(scope string, parameterName string) string {
+	switch scope {
+	case "USER":
+		return "customUser:" + parameterName
+	case "ITEM":
+		return "customItem:" + parameterName
+	default:
+		return "customEvent:" + parameterName
+	}
+}
+
+// getCustomDefinitions lists the custom dimensions, custom metrics and
+// calculated metrics of a property. The Admin API needs more permissions than
+// the Data API, so a failed list only leaves that part of the catalogue plain.
+func (client *GoogleClient) getCustomDefinitions(ctx context.Context, propertyId string) customDefinitions {
+	defs := customDefinitions{
+		dimensions: map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomDimension{},
+		metrics:    map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomMetric{},
+		calculated: map[string]*analyticsadminalpha.GoogleAnalyticsAdminV1alphaCalculatedMetric{},
+	}
+	if propertyId == "" {
+		return defs
+	}
+	parent := "properties/" + propertyId
+
+	if dimensions, err := client.getCustomDimensions(ctx, parent, ""); err != nil {
+		log.DefaultLogger.Warn("getCustomDefinitions: custom dimensions unavailable", "property", parent, "error", err.Error())
+	} else {
+		for _, dimension := range dimensions {
+			defs.dimensions[customDefinitionAPIName(dimension.Scope, dimension.ParameterName)] = dimension
+		}
+	}
+	if metrics, err := client.getCustomMetrics(ctx, parent, ""); err != nil {
+		log.DefaultLogger.Warn("getCustomDefinitions: custom metrics unavailable", "property", parent, "error", err.Error())
+	} else {
+		for _, metric := range metrics {
+			defs.metrics[customDefinitionAPIName(metric.Scope, metric.ParameterName)] = metric
+		}
+	}
+	if calculated, err := client.getCalculatedMetrics(ctx, parent, ""); err != nil {
+		log.DefaultLogger.Warn("getCustomDefinitions: calculated metrics unavailable", "property", parent, "error", err.Error())
+	} else {
+		for _, metric := range calculated {
+			defs.calculated["calculatedMetric:"+metric.CalculatedMetricId] = metric
+		}
+	}
+	return defs
+}
+
+// metadataItems converts Data API metadata into the catalogue the editor
+// reads, grouping custom definitions under GaCustomGroup and filling in
+// their scope, parameter name and formula from defs.
+func metadataItems(metadata *analyticsdata.Metadata, defs customDefinitions) ([]model.MetadataItem, []model.MetadataItem) {
+	metrics := make([]model.MetadataItem, len(metadata.Metrics))
+	for idx, metric := range metadata.Metrics {
+		item := model.MetadataItem{ID: metric.ApiName}
+		item.Attributes.Description = metric.Description
+		item.Attributes.Group = metric.Category
+		item.Attributes.UIName = metric.UiName
+		item.Attributes.DataType = metric.Type
+		item.Attributes.Expression = metric.Expression
+		item.Attributes.DeprecatedAPINames = metric.DeprecatedApiNames
+		item.Attributes.CustomDefinition = metric.CustomDefinition
+		if custom, ok := defs.metrics[metric.ApiName]; ok {
+			item.Attributes.CustomDefinition = true
+			item.Attributes.Scope = custom.Scope
+			item.Attributes.ParameterName = custom.ParameterName
+			if item.Attributes.Description == "" {
+				item.Attributes.Description = custom.Description
+			}
+		}
+		if calculated, ok := defs.calculated[metric.ApiName]; ok {
+			item.Attributes.CustomDefinition = true
+			item.Attributes.Expression = calculated.Formula
+			if item.Attributes.Description == "" {
+				item.Attributes.Description = calculated.Description
+			}
+		}
+		if item.Attributes.CustomDefinition {
+			item.Attributes.Group = GaCustomGroup
+		}
+		metrics[idx] = item
+	}
+
+	dimensions := make([]model.MetadataItem, len(metadata.Dimensions))
+	for idx, dimension := range metadata.Dimensions {
+		item := model.MetadataItem{ID: dimension.ApiName}
+		item.Attributes.Description = dimension.Description
+		item.Attributes.Group = dimension.Category
+		item.Attributes.UIName = dimension.UiName
+		item.Attributes.DeprecatedAPINames = dimension.DeprecatedApiNames
+		item.Attributes.CustomDefinition = dimension.CustomDefinition
+		if custom, ok := defs.dimensions[dimension.ApiName]; ok {
+			item.Attributes.CustomDefinition = true
+			item.Attributes.Scope = custom.Scope
+			item.Attributes.ParameterName = custom.ParameterName
+			if item.Attributes.Description == "" {
+				item.Attributes.Description = custom.Description
+			}
+		}
+		if item.Attributes.CustomDefinition {
+			item.Attributes.Group = GaCustomGroup
+		}
+		dimensions[idx] = item
+	}
+	return metrics, dimensions
+}
+
+// realtimeItems derives the realtime catalogue of a property. The Data API
+// has no realtime metadata, so the documented realtime fields in static are
+// matched against the property catalogue, which gives their current name and
+// description, and custom user-scoped dimensions are added when customUser
+// is set. Fields missing from the catalogue are kept as they are.
+func realtimeItems(static []model.MetadataItem, catalogue []model.MetadataItem, customUser bool) []model.MetadataItem {
+	byName := map[string]model.MetadataItem{}
+	for _, item := range catalogue {
+		byName[item.ID] = item
+		for _, deprecated := range item.Attributes.DeprecatedAPINames {
+			if _, ok := byName[deprecated]; !ok {
+				byName[deprecated] = item
+			}
+		}
+	}
+
+	items := make([]model.MetadataItem, 0, len(static))
+	seen := map[string]bool{}
+	for _, item := range static {
+		if current, ok := byName[item.ID]; ok {
+			item = current
+		}
+		if !seen[item.ID] {
+			seen[item.ID] = true
+			items = append(items, item)
+		}
+	}
+	if customUser {
+		for _, item := range catalogue {
+			if strings.HasPrefix(item.ID, "customUser:") && !seen[item.ID] {
+				seen[item.ID] = true
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
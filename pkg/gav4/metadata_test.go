@@ -0,0 +1,102 @@
+package gav4
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	analyticsadminalpha "google.golang.org/api/analyticsadmin/v1alpha"
+	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestCustomDefinitionAPIName(t *testing.T) {
+	tests := []struct {
+		scope, want string
+	}{
+		{"EVENT", "customEvent:plan"},
+		{"USER", "customUser:plan"},
+		{"ITEM", "customItem:plan"},
+	}
+	for _, tt := range tests {
+		if got := customDefinitionAPIName(tt.scope, "plan"); got != tt.want {
+			t.Errorf("customDefinitionAPIName(%q) = %q, want %q", tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestMetadataItems(t *testing.T) {
+	metadata := &analyticsdata.Metadata{
+		Dimensions: []*analyticsdata.DimensionMetadata{
+			{ApiName: "country", UiName: "Country", Category: "Geography", DeprecatedApiNames: []string{"countryName"}},
+			{ApiName: "customUser:plan", UiName: "Plan", Category: "User", CustomDefinition: true},
+		},
+		Metrics: []*analyticsdata.MetricMetadata{
+			{ApiName: "sessions", UiName: "Sessions", Category: "Session", Type: "TYPE_INTEGER"},
+			{ApiName: "customEvent:revenue", UiName: "Revenue", Category: "Event", Type: "TYPE_CURRENCY", CustomDefinition: true},
+			{ApiName: "calculatedMetric:ratio", UiName: "Ratio", Type: "TYPE_FLOAT", CustomDefinition: true},
+		},
+	}
+	defs := customDefinitions{
+		dimensions: map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomDimension{
+			"customUser:plan": {ParameterName: "plan", Scope: "USER", Description: "subscription plan"},
+		},
+		metrics: map[string]*analyticsadmin.GoogleAnalyticsAdminV1betaCustomMetric{
+			"customEvent:revenue": {ParameterName: "revenue", Scope: "EVENT"},
+		},
+		calculated: map[string]*analyticsadminalpha.GoogleAnalyticsAdminV1alphaCalculatedMetric{
+			"calculatedMetric:ratio": {CalculatedMetricId: "ratio", Formula: "{sessions} / {activeUsers}"},
+		},
+	}
+
+	metrics, dimensions := metadataItems(metadata, defs)
+
+	country := dimensions[0].Attributes
+	if country.Group != "Geography" || country.CustomDefinition || !reflect.DeepEqual(country.DeprecatedAPINames, []string{"countryName"}) {
+		t.Errorf("country = %+v", country)
+	}
+	plan := dimensions[1].Attributes
+	if plan.Group != GaCustomGroup || plan.Scope != "USER" || plan.ParameterName != "plan" || plan.Description != "subscription plan" {
+		t.Errorf("customUser:plan = %+v", plan)
+	}
+
+	if sessions := metrics[0].Attributes; sessions.DataType != "TYPE_INTEGER" || sessions.CustomDefinition {
+		t.Errorf("sessions = %+v", sessions)
+	}
+	if revenue := metrics[1].Attributes; revenue.Group != GaCustomGroup || revenue.Scope != "EVENT" || revenue.DataType != "TYPE_CURRENCY" {
+		t.Errorf("customEvent:revenue = %+v", revenue)
+	}
+	if ratio := metrics[2].Attributes; ratio.Group != GaCustomGroup || ratio.Expression != "{sessions} / {activeUsers}" {
+		t.Errorf("calculatedMetric:ratio = %+v", ratio)
+	}
+}
+
+func TestRealtimeItems(t *testing.T) {
+	static := []model.MetadataItem{{ID: "country"}, {ID: "conversions"}, {ID: "minutesAgo"}}
+	catalogue := []model.MetadataItem{
+		{ID: "country", Attributes: model.MetadataItemAttribute{UIName: "Country"}},
+		{ID: "keyEvents", Attributes: model.MetadataItemAttribute{DeprecatedAPINames: []string{"conversions"}}},
+		{ID: "customUser:plan"},
+		{ID: "customEvent:button"},
+		{ID: "calculatedMetric:ratio"},
+		{ID: "bounceRate"},
+	}
+
+	ids := func(items []model.MetadataItem) []string {
+		out := make([]string, len(items))
+		for i, item := range items {
+			out[i] = item.ID
+		}
+		return out
+	}
+	items := realtimeItems(static, catalogue, true)
+	if got, want := ids(items), []string{"country", "keyEvents", "minutesAgo", "customUser:plan"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("realtimeItems = %v, want %v", got, want)
+	}
+	if items[0].Attributes.UIName != "Country" {
+		t.Errorf("catalogue attributes should replace the static ones, got %+v", items[0])
+	}
+	if got, want := ids(realtimeItems(static, catalogue, false)), []string{"country", "keyEvents", "minutesAgo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("realtimeItems without custom = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+package gav4
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// Metrics are named grafana_plugin_googleanalytics_*
+const (
+	metricsNamespace = "grafana_plugin"
+	metricsSubsystem = "googleanalytics"
+)
+
+// Collectors of the plugin, registered with the default registry that the
+// plugin SDK serves on its metrics endpoint.
+var (
+	apiRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_requests_total",
+		Help:      "Google Analytics API calls by method, property and HTTP status.",
+	}, []string{"method", "property", "status"})
+
+	apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of Google Analytics API calls by method, property and HTTP status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "property", "status"})
+
+	apiRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "api_retries_total",
+		Help:      "Google Analytics API calls retried after a transient failure, by method.",
+	}, []string{"method"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cache_requests_total",
+		Help:      "Cache lookups by namespace and result, hit or miss.",
+	}, []string{"namespace", "result"})
+
+	reportRows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "report_rows_total",
+		Help:      "Rows returned by Google Analytics reports, by method.",
+	}, []string{"method"})
+
+	reportPages = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "report_pages",
+		Help:      "Pages fetched for one report.",
+		Buckets:   []float64{1, 2, 3, 5, 10, 20},
+	})
+
+	quotaTokensConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "quota_tokens_consumed_total",
+		Help:      "Property quota tokens consumed by reports, by property.",
+	}, []string{"property"})
+
+	quotaTokensRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "quota_tokens_remaining",
+		Help:      "Property quota tokens left after the last report, by property and period, day or hour.",
+	}, []string{"property", "period"})
+)
+
+// apiPropertyPattern finds the property of an API call in its URL path
+var apiPropertyPattern = regexp.MustCompile(`properties/[0-9]+`)
+
+// apiMethod names the API call of a Google API URL path: the custom method
+// after the colon (runReport), the collection that is listed (dataStreams) or
+// the resource type that is fetched (properties.get).
+func apiMethod(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	last := segments[len(segments)-1]
+	if i := strings.LastIndex(last, ":"); i >= 0 {
+		return last[i+1:]
+	}
+	if _, err := strconv.ParseInt(last, 10, 64); err == nil && len(segments) > 1 {
+		return segments[len(segments)-2] + ".get"
+	}
+	return last
+}
+
+const (
+	apiMaxRetries   = 2
+	apiRetryBackoff = 250 * time.Millisecond
+)
+
+// apiRetryable reports whether a call failed for a transient reason, a GA
+// outage rather than a bad request or an exhausted quota.
+func apiRetryable(res *http.Response, err error) bool {
+	if err != nil {
+		var netErr interface{ Timeout() bool }
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// apiMiddleware retries transient failures of the Google API calls and
+// records the count, latency and status of every attempt. All calls of the
+// plugin are reads, so retrying them is safe.
+func apiMiddleware() httpclient.Middleware {
+	return httpclient.NamedMiddlewareFunc("googleanalytics-api", func(opts httpclient.Options, next http.RoundTripper) http.RoundTripper {
+		return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			method := apiMethod(req.URL.Path)
+			property := apiPropertyPattern.FindString(req.URL.Path)
+			for attempt := 0; ; attempt++ {
+				start := time.Now()
+				res, err := next.RoundTrip(req)
+				status := "error"
+				if err == nil {
+					status = strconv.Itoa(res.StatusCode)
+				}
+				apiRequests.WithLabelValues(method, property, status).Inc()
+				apiRequestDuration.WithLabelValues(method, property, status).Observe(time.Since(start).Seconds())
+
+				if attempt == apiMaxRetries || !apiRetryable(res, err) || (req.Body != nil && req.GetBody == nil) {
+					return res, err
+				}
+				if res != nil {
+					res.Body.Close()
+				}
+				select {
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				case <-time.After(apiRetryBackoff << attempt):
+				}
+				req = req.Clone(req.Context())
+				if req.GetBody != nil {
+					body, err := req.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req.Body = body
+				}
+				apiRetries.WithLabelValues(method).Inc()
+			}
+		})
+	})
+}
+
+// observeQuota records the property quota a report returned
+func observeQuota(property string, quota *analyticsdata.PropertyQuota) {
+	if quota == nil {
+		return
+	}
+	if quota.TokensPerDay != nil {
+		quotaTokensConsumed.WithLabelValues(property).Add(float64(quota.TokensPerDay.Consumed))
+		quotaTokensRemaining.WithLabelValues(property, "day").Set(float64(quota.TokensPerDay.Remaining))
+	}
+	if quota.TokensPerHour != nil {
+		quotaTokensRemaining.WithLabelValues(property, "hour").Set(float64(quota.TokensPerHour.Remaining))
+	}
+}
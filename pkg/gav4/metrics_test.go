@@ -0,0 +1,136 @@
+package gav4
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestAPIMethod(t *testing.T) {
+	tests := map[string]string{
+		"/v1beta/properties/123:runReport":             "runReport",
+		"/v1beta/properties/123/metadata":              "metadata",
+		"/v1beta/accountSummaries":                     "accountSummaries",
+		"/v1beta/properties/123":                       "properties.get",
+		"/v1alpha/properties/123/calculatedMetrics":    "calculatedMetrics",
+		"/v1beta/accounts/1:searchChangeHistoryEvents": "searchChangeHistoryEvents",
+		"/v1beta/properties/123/dataStreams":           "dataStreams",
+	}
+	for path, want := range tests {
+		if got := apiMethod(path); got != want {
+			t.Errorf("apiMethod(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestAPIMiddleware(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"limit":"1"}` {
+			t.Errorf("attempt %d body = %q", calls, body)
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client, err := httpclient.New(httpclient.Options{Middlewares: []httpclient.Middleware{apiMiddleware()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	retries := testutil.ToFloat64(apiRetries.WithLabelValues("runReport"))
+	unavailable := testutil.ToFloat64(apiRequests.WithLabelValues("runReport", "properties/42", "503"))
+
+	res, err := client.Post(server.URL+"/v1beta/properties/42:runReport", "application/json", strings.NewReader(`{"limit":"1"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || calls != 2 {
+		t.Errorf("status %d after %d calls, want 200 after 2", res.StatusCode, calls)
+	}
+	if got := testutil.ToFloat64(apiRetries.WithLabelValues("runReport")) - retries; got != 1 {
+		t.Errorf("retries = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(apiRequests.WithLabelValues("runReport", "properties/42", "503")) - unavailable; got != 1 {
+		t.Errorf("503 requests = %v, want 1", got)
+	}
+}
+
+func TestAPIMiddleware_NoRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client, err := httpclient.New(httpclient.Options{Middlewares: []httpclient.Middleware{apiMiddleware()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(server.URL + "/v1beta/properties/42/metadata")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res.Body.Close()
+	if calls != 1 {
+		t.Errorf("an exhausted quota was retried, %d calls", calls)
+	}
+}
+
+func TestAPIMiddleware_NetworkErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}, 1},
+		{"dns", &net.DNSError{Err: "no such host", Name: "analyticsdata.googleapis.com"}, 1},
+		{"timeout", &net.DNSError{Err: "i/o timeout", Name: "analyticsdata.googleapis.com", IsTimeout: true}, apiMaxRetries + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			transport := apiMiddleware().CreateMiddleware(httpclient.Options{}, httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls++
+				return nil, tt.err
+			}))
+			req, _ := http.NewRequest(http.MethodGet, "https://analyticsdata.googleapis.com/v1beta/properties/42/metadata", nil)
+			if _, err := transport.RoundTrip(req); err == nil {
+				t.Fatal("expected an error")
+			}
+			if calls != tt.calls {
+				t.Errorf("%d calls, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestObserveQuota(t *testing.T) {
+	consumed := testutil.ToFloat64(quotaTokensConsumed.WithLabelValues("properties/7"))
+	observeQuota("properties/7", &analyticsdata.PropertyQuota{
+		TokensPerDay:  &analyticsdata.QuotaStatus{Consumed: 12, Remaining: 199000},
+		TokensPerHour: &analyticsdata.QuotaStatus{Consumed: 12, Remaining: 39000},
+	})
+	if got := testutil.ToFloat64(quotaTokensConsumed.WithLabelValues("properties/7")) - consumed; got != 12 {
+		t.Errorf("consumed = %v, want 12", got)
+	}
+	if got := testutil.ToFloat64(quotaTokensRemaining.WithLabelValues("properties/7", "hour")); got != 39000 {
+		t.Errorf("hourly remaining = %v, want 39000", got)
+	}
+	observeQuota("properties/7", nil)
+}
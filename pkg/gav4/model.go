@@ -22,6 +22,22 @@ func GetQueryModel(query backend.DataQuery) (*model.QueryModel, error) {
 		return nil, fmt.Errorf("error reading query: %s", err.Error())
 	}
 
+	if err := interpolateQueryModel(model); err != nil {
+		return nil, fmt.Errorf("error interpolating variables: %s", err.Error())
+	}
+	if err := applyRawQuery(model); err != nil {
+		return nil, err
+	}
+	if err := applyFiltersExpression(model); err != nil {
+		return nil, err
+	}
+
+	// WebPropertyID stays the first property so single property code paths keep working
+	model.WebPropertyIDs = propertyIDs(model)
+	if len(model.WebPropertyIDs) > 0 {
+		model.WebPropertyID = model.WebPropertyIDs[0]
+	}
+
 	// Copy directly from the well typed query
 	timezone, err := time.LoadLocation(model.Timezone)
 	if err != nil {
@@ -0,0 +1,295 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"go.opentelemetry.io/otel/trace"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// propertyIDs returns the distinct properties a query runs against, in order.
+func propertyIDs(queryModel *model.QueryModel) []string {
+	ids := make([]string, 0, len(queryModel.WebPropertyIDs)+1)
+	seen := map[string]struct{}{}
+	for _, id := range append([]string{queryModel.WebPropertyID}, queryModel.WebPropertyIDs...) {
+		if id == "" {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// queryProperties runs the report against every property of queryModel, at
+// most GaMaxConcurrentProperties at a time, and returns either the frames of
+// each property or one frame of the summed reports.
+//
+// Every property reports dates in its own timezone, while the frames use the
+// query's timezone, the one of the property picked in the editor. Properties
+// in other timezones are not shifted, their days are taken as they are.
+func (ga *GoogleAnalytics) queryProperties(ctx context.Context, config *setting.DatasourceSecretSettings, client *GoogleClient, queryModel *model.QueryModel) (*data.Frames, error) {
+	if queryModel.PropertyMerge == model.PropertyMergeSum {
+		for _, metric := range queryModel.Metrics {
+			if !additiveMetric(metric) {
+				return nil, invalidQuery(fmt.Errorf("metric %s is a ratio or an average and cannot be summed over properties, sum its counts and divide them in a calculated field", metric))
+			}
+		}
+	}
+	properties := queryModel.WebPropertyIDs
+	reports := make([]*analyticsdata.RunReportResponse, len(properties))
+	cached := make([]bool, len(properties))
+	errs := make([]error, len(properties))
+	ttl := reportCacheDuration(config, queryModel)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, GaMaxConcurrentProperties)
+	for i, property := range properties {
+		wg.Add(1)
+		go func(i int, property string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			propertyQuery := *queryModel
+			propertyQuery.WebPropertyID = property
+			propertyQuery.WebPropertyIDs = nil
+			report, hit, err := ga.getCachedReport(ctx, client, &propertyQuery, ttl)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", property, explainBadRequest(client, &propertyQuery, err))
+				return
+			}
+			reports[i] = report
+			cached[i] = hit
+		}(i, property)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		log.DefaultLogger.Error("queryProperties", "error", err)
+		return nil, err
+	}
+
+	allCached := true
+	for _, hit := range cached {
+		allCached = allCached && hit
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attributeCacheHit.Bool(allCached))
+
+	if queryModel.PropertyMerge == model.PropertyMergeSum {
+		// calculated fields are evaluated over the sums, a ratio of totals
+		merged := sumReports(reports, reportOrderBys(*queryModel))
+		if err := applyCalculatedFields(merged, queryModel.CalculatedFields); err != nil {
+			return nil, err
+		}
+		frames, err := transformReportsResponseToDataFrames(ctx, merged, queryModel.RefID, queryModel.Timezone, queryModel.Mode, queryModel.From, queryModel.To)
+		if err != nil {
+			return nil, err
+		}
+		setCacheMeta(*frames, allCached)
+		return frames, nil
+	}
+
+	names := ga.propertyDisplayNames(ctx, config)
+	frames := data.Frames{}
+	for i, report := range reports {
+		if err := applyCalculatedFields(report, queryModel.CalculatedFields); err != nil {
+			return nil, err
+		}
+		propertyFrames, err := transformReportsResponseToDataFrames(ctx, report, queryModel.RefID, queryModel.Timezone, queryModel.Mode, queryModel.From, queryModel.To)
+		if err != nil {
+			return nil, err
+		}
+		label := properties[i]
+		if name, ok := names[label]; ok && name != "" {
+			label = name
+		}
+		labelPropertyFrames(*propertyFrames, label)
+		setCacheMeta(*propertyFrames, cached[i])
+		frames = append(frames, *propertyFrames...)
+	}
+	return &frames, nil
+}
+
+// propertyDisplayNames maps property IDs to display names. A failure only
+// costs the labels, so it is logged and the IDs are used instead.
+func (ga *GoogleAnalytics) propertyDisplayNames(ctx context.Context, config *setting.DatasourceSecretSettings) map[string]string {
+	names := map[string]string{}
+	accountSummaries, err := ga.GetAccountSummaries(ctx, config)
+	if err != nil {
+		log.DefaultLogger.Warn("propertyDisplayNames: Fail GetAccountSummaries", "error", err.Error())
+		return names
+	}
+	for _, account := range accountSummaries {
+		for _, property := range account.PropertySummaries {
+			names[property.Property] = property.DisplayName
+		}
+	}
+	return names
+}
+
+// labelPropertyFrames prefixes frame names and metric display names with the
+// property label, so that series of different properties can be told apart.
+func labelPropertyFrames(frames data.Frames, label string) {
+	for _, frame := range frames {
+		if frame.Name == "" || frame.Name == frame.RefID {
+			frame.Name = label
+		} else {
+			frame.Name = label + " " + frame.Name
+		}
+		for _, field := range frame.Fields {
+			if field.Type() != data.FieldTypeNullableFloat64 {
+				continue
+			}
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			displayName := field.Config.DisplayName
+			if displayName == "" {
+				displayName = field.Name
+			}
+			field.Config.DisplayName = label + " " + displayName
+		}
+	}
+}
+
+// additiveMetric reports whether the values of metric can be added up over
+// properties: counts and totals can, rates, averages and per-unit ratios such
+// as bounceRate, averageSessionDuration or sessionsPerUser can not.
+func additiveMetric(metric string) bool {
+	name := strings.TrimPrefix(metric, "ga:")
+	if strings.HasPrefix(name, "average") || name == "returnOnAdSpend" {
+		return false
+	}
+	for _, part := range []string{"Rate", "Per", "Average"} {
+		if strings.Contains(name, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// sumReports merges reports with the same headers into one, adding up the
+// metric values of rows whose dimension values are equal. Only additive
+// metrics such as sessions or eventCount give meaningful sums. The merged rows
+// are sorted by orderBys, as GA sorts the rows of a single report.
+func sumReports(reports []*analyticsdata.RunReportResponse, orderBys []*analyticsdata.OrderBy) *analyticsdata.RunReportResponse {
+	merged := &analyticsdata.RunReportResponse{}
+	if len(reports) == 0 {
+		return merged
+	}
+	merged.DimensionHeaders = reports[0].DimensionHeaders
+	merged.MetricHeaders = reports[0].MetricHeaders
+
+	rows := map[string]*analyticsdata.Row{}
+	sums := map[string][]float64{}
+	for _, report := range reports {
+		for _, row := range report.Rows {
+			values := make([]string, len(row.DimensionValues))
+			for i, v := range row.DimensionValues {
+				values[i] = v.Value
+			}
+			key := strings.Join(values, "\x00")
+			if _, ok := rows[key]; !ok {
+				rows[key] = &analyticsdata.Row{DimensionValues: row.DimensionValues}
+				sums[key] = make([]float64, len(merged.MetricHeaders))
+				merged.Rows = append(merged.Rows, rows[key])
+			}
+			for i, v := range row.MetricValues {
+				if i >= len(sums[key]) {
+					break
+				}
+				n, err := strconv.ParseFloat(v.Value, 64)
+				if err != nil {
+					continue
+				}
+				sums[key][i] += n
+			}
+		}
+	}
+	for key, row := range rows {
+		row.MetricValues = make([]*analyticsdata.MetricValue, len(sums[key]))
+		for i, sum := range sums[key] {
+			row.MetricValues[i] = &analyticsdata.MetricValue{Value: strconv.FormatFloat(sum, 'f', -1, 64)}
+		}
+	}
+	sortRows(merged, orderBys)
+	merged.RowCount = int64(len(merged.Rows))
+	return merged
+}
+
+// sortRows sorts the rows of report by orderBys. Dimensions compare as
+// strings, which keeps date, dateHour and the like in time order, unless
+// ordered numerically; metrics compare as numbers.
+func sortRows(report *analyticsdata.RunReportResponse, orderBys []*analyticsdata.OrderBy) {
+	dimensions := map[string]int{}
+	for i, header := range report.DimensionHeaders {
+		dimensions[header.Name] = i
+	}
+	metrics := map[string]int{}
+	for i, header := range report.MetricHeaders {
+		metrics[header.Name] = i
+	}
+	compare := func(a, b *analyticsdata.Row, orderBy *analyticsdata.OrderBy) int {
+		switch {
+		case orderBy.Dimension != nil:
+			i, ok := dimensions[orderBy.Dimension.DimensionName]
+			if !ok || i >= len(a.DimensionValues) || i >= len(b.DimensionValues) {
+				return 0
+			}
+			x, y := a.DimensionValues[i].Value, b.DimensionValues[i].Value
+			switch orderBy.Dimension.OrderType {
+			case "NUMERIC":
+				return compareNumbers(x, y)
+			case "CASE_INSENSITIVE_ALPHANUMERIC":
+				return strings.Compare(strings.ToLower(x), strings.ToLower(y))
+			}
+			return strings.Compare(x, y)
+		case orderBy.Metric != nil:
+			i, ok := metrics[orderBy.Metric.MetricName]
+			if !ok || i >= len(a.MetricValues) || i >= len(b.MetricValues) {
+				return 0
+			}
+			return compareNumbers(a.MetricValues[i].Value, b.MetricValues[i].Value)
+		}
+		return 0
+	}
+	sort.SliceStable(report.Rows, func(i, j int) bool {
+		for _, orderBy := range orderBys {
+			c := compare(report.Rows[i], report.Rows[j], orderBy)
+			if orderBy.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareNumbers(x, y string) int {
+	a, errA := strconv.ParseFloat(x, 64)
+	b, errB := strconv.ParseFloat(y, 64)
+	switch {
+	case errA != nil || errB != nil:
+		return strings.Compare(x, y)
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package gav4
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestGetQueryModel_MultiValuePropertyVariable(t *testing.T) {
+	b, _ := json.Marshal(map[string]interface{}{
+		"webPropertyId":  "$site",
+		"webPropertyIds": []string{"properties/3", "properties/1"},
+		"timezone":       "UTC",
+		"variables":      map[string][]string{"site": {"properties/1", "properties/2"}},
+	})
+	queryModel, err := GetQueryModel(backend.DataQuery{
+		JSON:      b,
+		TimeRange: backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"properties/1", "properties/2", "properties/3"}
+	if !reflect.DeepEqual(queryModel.WebPropertyIDs, want) {
+		t.Errorf("WebPropertyIDs = %v, want %v", queryModel.WebPropertyIDs, want)
+	}
+	if queryModel.WebPropertyID != "properties/1" {
+		t.Errorf("WebPropertyID = %q", queryModel.WebPropertyID)
+	}
+}
+
+func TestSumReports(t *testing.T) {
+	row := func(date, country, sessions string) *analyticsdata.Row {
+		return &analyticsdata.Row{
+			DimensionValues: []*analyticsdata.DimensionValue{{Value: date}, {Value: country}},
+			MetricValues:    []*analyticsdata.MetricValue{{Value: sessions}},
+		}
+	}
+	headers := []*analyticsdata.MetricHeader{{Name: "sessions", Type: "TYPE_INTEGER"}}
+	dimensions := []*analyticsdata.DimensionHeader{{Name: "date"}, {Name: "country"}}
+	reports := []*analyticsdata.RunReportResponse{
+		{DimensionHeaders: dimensions, MetricHeaders: headers, Rows: []*analyticsdata.Row{row("20240102", "US", "1"), row("20240103", "KR", "10")}},
+		{DimensionHeaders: dimensions, MetricHeaders: headers, Rows: []*analyticsdata.Row{row("20240101", "KR", "2.5"), row("20240103", "KR", "5")}},
+	}
+
+	merged := sumReports(reports, []*analyticsdata.OrderBy{{Dimension: &analyticsdata.DimensionOrderBy{DimensionName: "date"}}})
+	got := map[string]string{}
+	for _, r := range merged.Rows {
+		got[r.DimensionValues[0].Value+"/"+r.DimensionValues[1].Value] = r.MetricValues[0].Value
+	}
+	want := map[string]string{"20240103/KR": "15", "20240102/US": "1", "20240101/KR": "2.5"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sumReports rows = %v, want %v", got, want)
+	}
+	if merged.RowCount != 3 {
+		t.Errorf("RowCount = %d", merged.RowCount)
+	}
+	var dates []string
+	for _, r := range merged.Rows {
+		dates = append(dates, r.DimensionValues[0].Value)
+	}
+	if !reflect.DeepEqual(dates, []string{"20240101", "20240102", "20240103"}) {
+		t.Errorf("merged rows must be sorted by date, got %v", dates)
+	}
+
+	byMetric := sumReports(reports, []*analyticsdata.OrderBy{{Metric: &analyticsdata.MetricOrderBy{MetricName: "sessions"}, Desc: true}})
+	if v := byMetric.Rows[0].MetricValues[0].Value; v != "15" {
+		t.Errorf("first row by descending sessions = %s, want 15", v)
+	}
+}
+
+func TestAdditiveMetric(t *testing.T) {
+	for metric, want := range map[string]bool{
+		"sessions":                  true,
+		"eventCount":                true,
+		"userEngagementDuration":    true,
+		"bounceRate":                false,
+		"averageSessionDuration":    false,
+		"sessionsPerUser":           false,
+		"screenPageViewsPerSession": false,
+	} {
+		if got := additiveMetric(metric); got != want {
+			t.Errorf("additiveMetric(%s) = %v, want %v", metric, got, want)
+		}
+	}
+}
+
+func TestLabelPropertyFrames(t *testing.T) {
+	value := 1.0
+	frames := data.Frames{
+		data.NewFrame("A",
+			data.NewField("dateHour", nil, []*time.Time{nil}),
+			data.NewField("sessions", nil, []*float64{&value}).SetConfig(&data.FieldConfig{DisplayName: "KR|sessions"}),
+		),
+	}
+	frames[0].RefID = "A"
+	frames[0].Name = "KR|"
+
+	labelPropertyFrames(frames, "Korea site")
+	if frames[0].Name != "Korea site KR|" {
+		t.Errorf("frame name = %q", frames[0].Name)
+	}
+	if frames[0].Fields[0].Config != nil {
+		t.Errorf("time field must not be labelled")
+	}
+	if got := frames[0].Fields[1].Config.DisplayName; got != "Korea site KR|sessions" {
+		t.Errorf("display name = %q", got)
+	}
+}
+
+func TestPropertyIDs(t *testing.T) {
+	got := propertyIDs(&model.QueryModel{WebPropertyID: "", WebPropertyIDs: []string{"properties/2", "", "properties/2"}})
+	if !reflect.DeepEqual(got, []string{"properties/2"}) {
+		t.Errorf("propertyIDs = %v", got)
+	}
+}
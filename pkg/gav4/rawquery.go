@@ -0,0 +1,696 @@
+package gav4
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// A raw query is a single statement in a small SQL like language:
+//
+//	SELECT sessions, activeUsers BY date, country
+//	WHERE country IN ('US', 'CA') AND sessions > 10
+//	ORDER BY sessions DESC LIMIT 20
+//
+// SELECT lists metrics and BY dimensions. WHERE supports AND, OR, NOT,
+// parentheses, = != <> < <= > >=, =~ and !~ for regular expressions,
+// [NOT] IN (...), [NOT] LIKE with % and _ wildcards and BETWEEN x AND y.
+// Conditions on selected metrics, or numeric comparisons on other fields,
+// become the metric filter and the rest the dimension filter.
+
+// rawQueryError reports where a raw query statement went wrong.
+type rawQueryError struct {
+	line, column int
+	msg          string
+}
+
+func (e *rawQueryError) Error() string {
+	return fmt.Sprintf("raw query: line %d, column %d: %s", e.line, e.column, e.msg)
+}
+
+type rawTokenKind int
+
+const (
+	rawTokenEOF rawTokenKind = iota
+	rawTokenIdent
+	rawTokenString
+	rawTokenNumber
+	rawTokenOperator
+	rawTokenComma
+	rawTokenOpen
+	rawTokenClose
+)
+
+type rawToken struct {
+	kind         rawTokenKind
+	text         string
+	line, column int
+}
+
+func (t rawToken) describe() string {
+	switch t.kind {
+	case rawTokenEOF:
+		return "end of query"
+	case rawTokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+func (t rawToken) errorf(format string, args ...interface{}) error {
+	return &rawQueryError{t.line, t.column, fmt.Sprintf(format, args...)}
+}
+
+// rawOperators in match order, two character operators first.
+var rawOperators = []string{"<=", ">=", "<>", "!=", "=~", "!~", "=", "<", ">"}
+
+var rawKeywords = []string{"SELECT", "BY", "WHERE", "ORDER", "LIMIT", "AND", "OR", "NOT", "IN", "LIKE", "BETWEEN", "ASC", "DESC"}
+
+func isRawKeyword(s string) bool {
+	for _, keyword := range rawKeywords {
+		if strings.EqualFold(s, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func lexRawQuery(s string) ([]rawToken, error) {
+	var tokens []rawToken
+	line, column := 1, 1
+	i := 0
+	advance := func(n int) {
+		for ; n > 0 && i < len(s); n-- {
+			switch {
+			case s[i] == '\n':
+				line++
+				column = 1
+			case s[i]&0xC0 != 0x80:
+				// count characters, not UTF-8 continuation bytes
+				column++
+			}
+			i++
+		}
+	}
+
+	for i < len(s) {
+		c := s[i]
+		token := rawToken{line: line, column: column}
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			advance(1)
+			continue
+		case c == ',':
+			token.kind, token.text = rawTokenComma, ","
+			advance(1)
+		case c == '(':
+			token.kind, token.text = rawTokenOpen, "("
+			advance(1)
+		case c == ')':
+			token.kind, token.text = rawTokenClose, ")"
+			advance(1)
+		case c == '\'' || c == '"':
+			// A doubled quote stands for the quote itself
+			var b strings.Builder
+			advance(1)
+			for {
+				if i >= len(s) {
+					return nil, token.errorf("unterminated string")
+				}
+				if s[i] == c {
+					if i+1 < len(s) && s[i+1] == c {
+						b.WriteByte(c)
+						advance(2)
+						continue
+					}
+					advance(1)
+					break
+				}
+				b.WriteByte(s[i])
+				advance(1)
+			}
+			token.kind, token.text = rawTokenString, b.String()
+		case isDigit(c) || (c == '-' || c == '.') && i+1 < len(s) && isDigit(s[i+1]):
+			j := i + 1
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			token.kind, token.text = rawTokenNumber, s[i:j]
+			advance(j - i)
+		case isFieldNameChar(c):
+			j := i + 1
+			for j < len(s) && isFieldNameChar(s[j]) {
+				j++
+			}
+			token.kind, token.text = rawTokenIdent, s[i:j]
+			advance(j - i)
+		default:
+			for _, op := range rawOperators {
+				if strings.HasPrefix(s[i:], op) {
+					token.kind, token.text = rawTokenOperator, op
+					break
+				}
+			}
+			if token.kind != rawTokenOperator {
+				return nil, token.errorf("unexpected character %q", s[i:i+1])
+			}
+			advance(len(token.text))
+		}
+		tokens = append(tokens, token)
+	}
+	return append(tokens, rawToken{kind: rawTokenEOF, line: line, column: column}), nil
+}
+
+// rawStatement is a parsed raw query.
+type rawStatement struct {
+	metrics         []string
+	dimensions      []string
+	dimensionFilter *analyticsdata.FilterExpression
+	metricFilter    *analyticsdata.FilterExpression
+	orderBys        []*analyticsdata.OrderBy
+	limit           int64
+}
+
+// rawCondition is a node of the WHERE clause. AND nodes keep their children
+// so that the top level can be split into dimension and metric filters.
+type rawCondition struct {
+	at         rawToken
+	metric     bool
+	mixed      bool
+	expression *analyticsdata.FilterExpression
+	and        []*rawCondition
+}
+
+func (c *rawCondition) filterExpression() *analyticsdata.FilterExpression {
+	if c.and == nil {
+		return c.expression
+	}
+	expressions := make([]*analyticsdata.FilterExpression, len(c.and))
+	for i, child := range c.and {
+		expressions[i] = child.filterExpression()
+	}
+	return &analyticsdata.FilterExpression{AndGroup: &analyticsdata.FilterExpressionList{Expressions: expressions}}
+}
+
+// conjuncts flattens the top level AND nodes of c.
+func (c *rawCondition) conjuncts() []*rawCondition {
+	if c.and == nil {
+		return []*rawCondition{c}
+	}
+	var conjuncts []*rawCondition
+	for _, child := range c.and {
+		conjuncts = append(conjuncts, child.conjuncts()...)
+	}
+	return conjuncts
+}
+
+type rawQueryParser struct {
+	tokens     []rawToken
+	pos        int
+	metrics    []string
+	dimensions []string
+}
+
+// parseRawQuery parses a raw query statement, see the top of this file.
+func parseRawQuery(text string) (*rawStatement, error) {
+	tokens, err := lexRawQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &rawQueryParser{tokens: tokens}
+	return p.statement()
+}
+
+func (p *rawQueryParser) peek() rawToken {
+	return p.tokens[p.pos]
+}
+
+func (p *rawQueryParser) next() rawToken {
+	token := p.tokens[p.pos]
+	if token.kind != rawTokenEOF {
+		p.pos++
+	}
+	return token
+}
+
+func (p *rawQueryParser) acceptKeyword(keyword string) bool {
+	token := p.peek()
+	if token.kind == rawTokenIdent && strings.EqualFold(token.text, keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *rawQueryParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		token := p.peek()
+		return token.errorf("expected %s, got %s", keyword, token.describe())
+	}
+	return nil
+}
+
+func (p *rawQueryParser) expect(kind rawTokenKind, what string) (rawToken, error) {
+	token := p.next()
+	if token.kind != kind {
+		return token, token.errorf("expected %s, got %s", what, token.describe())
+	}
+	return token, nil
+}
+
+func (p *rawQueryParser) field() (rawToken, error) {
+	token := p.next()
+	if token.kind != rawTokenIdent || isRawKeyword(token.text) {
+		return token, token.errorf("expected a field name, got %s", token.describe())
+	}
+	return token, nil
+}
+
+func (p *rawQueryParser) fieldList() ([]string, error) {
+	var fields []string
+	for {
+		token, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, token.text)
+		if p.peek().kind != rawTokenComma {
+			return fields, nil
+		}
+		p.next()
+	}
+}
+
+func (p *rawQueryParser) statement() (*rawStatement, error) {
+	statement := &rawStatement{}
+	var err error
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if statement.metrics, err = p.fieldList(); err != nil {
+		return nil, err
+	}
+	p.metrics = statement.metrics
+	if p.acceptKeyword("BY") {
+		if statement.dimensions, err = p.fieldList(); err != nil {
+			return nil, err
+		}
+		p.dimensions = statement.dimensions
+	}
+
+	if p.acceptKeyword("WHERE") {
+		condition, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		var dimensionConditions, metricConditions []*analyticsdata.FilterExpression
+		for _, conjunct := range condition.conjuncts() {
+			if conjunct.metric {
+				metricConditions = append(metricConditions, conjunct.filterExpression())
+			} else {
+				dimensionConditions = append(dimensionConditions, conjunct.filterExpression())
+			}
+		}
+		statement.dimensionFilter = andExpressions(dimensionConditions)
+		statement.metricFilter = andExpressions(metricConditions)
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if statement.orderBys, err = p.orderBys(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		token, err := p.expect(rawTokenNumber, "a row limit")
+		if err != nil {
+			return nil, err
+		}
+		limit, err := strconv.ParseInt(token.text, 10, 64)
+		if err != nil || limit <= 0 || limit > GaReportMaxResult {
+			return nil, token.errorf("LIMIT must be a whole number between 1 and %d", GaReportMaxResult)
+		}
+		statement.limit = limit
+	}
+
+	if token := p.peek(); token.kind != rawTokenEOF {
+		return nil, token.errorf("unexpected %s", token.describe())
+	}
+	return statement, nil
+}
+
+func (p *rawQueryParser) orderBys() ([]*analyticsdata.OrderBy, error) {
+	var orderBys []*analyticsdata.OrderBy
+	for {
+		token, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		orderBy := &analyticsdata.OrderBy{}
+		switch {
+		case util.Contains(p.metrics, token.text):
+			orderBy.Metric = &analyticsdata.MetricOrderBy{MetricName: token.text}
+		case util.Contains(p.dimensions, token.text):
+			orderBy.Dimension = &analyticsdata.DimensionOrderBy{DimensionName: token.text}
+		default:
+			return nil, token.errorf("ORDER BY field %q is not in SELECT or BY", token.text)
+		}
+		if p.acceptKeyword("DESC") {
+			orderBy.Desc = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		orderBys = append(orderBys, orderBy)
+		if p.peek().kind != rawTokenComma {
+			return orderBys, nil
+		}
+		p.next()
+	}
+}
+
+// uniform checks that the children of an OR or NOT only filter dimensions or
+// only metrics, as GA4 keeps the two filters apart.
+func uniform(operator string, conditions ...*rawCondition) error {
+	for _, c := range conditions {
+		if c.mixed || c.metric != conditions[0].metric {
+			return c.at.errorf("dimensions and metrics cannot be combined with %s", operator)
+		}
+	}
+	return nil
+}
+
+func (p *rawQueryParser) or() (*rawCondition, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	conditions := []*rawCondition{left}
+	for p.acceptKeyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, right)
+	}
+	if len(conditions) == 1 {
+		return left, nil
+	}
+	if err := uniform("OR", conditions...); err != nil {
+		return nil, err
+	}
+	expressions := make([]*analyticsdata.FilterExpression, len(conditions))
+	for i, c := range conditions {
+		expressions[i] = c.filterExpression()
+	}
+	return &rawCondition{
+		at:         left.at,
+		metric:     left.metric,
+		expression: &analyticsdata.FilterExpression{OrGroup: &analyticsdata.FilterExpressionList{Expressions: expressions}},
+	}, nil
+}
+
+func (p *rawQueryParser) and() (*rawCondition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	conditions := []*rawCondition{left}
+	for p.acceptKeyword("AND") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, right)
+	}
+	if len(conditions) == 1 {
+		return left, nil
+	}
+	return &rawCondition{
+		at:     left.at,
+		metric: left.metric,
+		mixed:  uniform("AND", conditions...) != nil,
+		and:    conditions,
+	}, nil
+}
+
+func (p *rawQueryParser) unary() (*rawCondition, error) {
+	token := p.peek()
+	if p.acceptKeyword("NOT") {
+		c, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if err := uniform("NOT", c); err != nil {
+			return nil, err
+		}
+		return &rawCondition{
+			at:         token,
+			metric:     c.metric,
+			expression: &analyticsdata.FilterExpression{NotExpression: c.filterExpression()},
+		}, nil
+	}
+	if token.kind == rawTokenOpen {
+		p.next()
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(rawTokenClose, ")"); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return p.predicate()
+}
+
+// isMetric decides which filter a condition on field belongs to.
+func (p *rawQueryParser) isMetric(field string, numeric bool) bool {
+	if util.Contains(p.metrics, field) {
+		return true
+	}
+	if util.Contains(p.dimensions, field) {
+		return false
+	}
+	return numeric
+}
+
+func (p *rawQueryParser) value() (rawToken, error) {
+	token := p.next()
+	if token.kind != rawTokenString && token.kind != rawTokenNumber {
+		return token, token.errorf("expected a value, got %s", token.describe())
+	}
+	return token, nil
+}
+
+func (p *rawQueryParser) number() (*analyticsdata.NumericValue, error) {
+	token, err := p.expect(rawTokenNumber, "a number")
+	if err != nil {
+		return nil, err
+	}
+	value, ok := parseNumericValue(token.text)
+	if !ok {
+		return nil, token.errorf("%q is not a number", token.text)
+	}
+	return value, nil
+}
+
+func (p *rawQueryParser) predicate() (*rawCondition, error) {
+	fieldToken, err := p.field()
+	if err != nil {
+		return nil, err
+	}
+	field := fieldToken.text
+	c := &rawCondition{at: fieldToken}
+	filter := &analyticsdata.Filter{FieldName: field}
+	negate := p.acceptKeyword("NOT")
+
+	switch {
+	case p.acceptKeyword("IN"):
+		if p.isMetric(field, false) {
+			return nil, fieldToken.errorf("IN is not supported for metric %q", field)
+		}
+		if _, err := p.expect(rawTokenOpen, "("); err != nil {
+			return nil, err
+		}
+		var values []string
+		for {
+			token, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, token.text)
+			if p.peek().kind != rawTokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(rawTokenClose, ")"); err != nil {
+			return nil, err
+		}
+		filter.InListFilter = &analyticsdata.InListFilter{Values: values, CaseSensitive: true}
+	case p.acceptKeyword("LIKE"):
+		if p.isMetric(field, false) {
+			return nil, fieldToken.errorf("LIKE is not supported for metric %q", field)
+		}
+		token, err := p.expect(rawTokenString, "a LIKE pattern")
+		if err != nil {
+			return nil, err
+		}
+		filter.StringFilter = likeFilter(token.text)
+	case negate:
+		token := p.peek()
+		return nil, token.errorf("expected IN or LIKE after NOT, got %s", token.describe())
+	case p.acceptKeyword("BETWEEN"):
+		if !p.isMetric(field, true) {
+			return nil, fieldToken.errorf("BETWEEN is not supported for dimension %q", field)
+		}
+		from, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return nil, err
+		}
+		to, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		c.metric = true
+		filter.BetweenFilter = &analyticsdata.BetweenFilter{FromValue: from, ToValue: to}
+	default:
+		operator, err := p.expect(rawTokenOperator, "an operator")
+		if err != nil {
+			return nil, err
+		}
+		valueToken, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		comparison := strings.ContainsAny(operator.text, "<>") && operator.text != "<>"
+		c.metric = p.isMetric(field, comparison || valueToken.kind == rawTokenNumber)
+		if c.metric {
+			negate, err = metricComparison(filter, operator, valueToken)
+		} else {
+			negate, err = dimensionComparison(filter, operator, valueToken)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.expression = &analyticsdata.FilterExpression{Filter: filter}
+	if negate {
+		c.expression = &analyticsdata.FilterExpression{NotExpression: c.expression}
+	}
+	return c, nil
+}
+
+// metricComparison fills in a numeric filter and tells whether it is negated.
+func metricComparison(filter *analyticsdata.Filter, operator rawToken, valueToken rawToken) (bool, error) {
+	operations := map[string]string{
+		"=":  "EQUAL",
+		"!=": "EQUAL",
+		"<>": "EQUAL",
+		"<":  "LESS_THAN",
+		"<=": "LESS_THAN_OR_EQUAL",
+		">":  "GREATER_THAN",
+		">=": "GREATER_THAN_OR_EQUAL",
+	}
+	operation, ok := operations[operator.text]
+	if !ok {
+		return false, operator.errorf("operator %s is not supported for metric %q", operator.text, filter.FieldName)
+	}
+	value, ok := parseNumericValue(valueToken.text)
+	if !ok {
+		return false, valueToken.errorf("metric %q needs a number, got %s", filter.FieldName, valueToken.describe())
+	}
+	filter.NumericFilter = &analyticsdata.NumericFilter{Operation: operation, Value: value}
+	return operator.text == "!=" || operator.text == "<>", nil
+}
+
+// dimensionComparison fills in a string filter and tells whether it is negated.
+func dimensionComparison(filter *analyticsdata.Filter, operator rawToken, valueToken rawToken) (bool, error) {
+	switch operator.text {
+	case "=", "!=", "<>":
+		filter.StringFilter = &analyticsdata.StringFilter{MatchType: "EXACT", Value: valueToken.text, CaseSensitive: true}
+		return operator.text != "=", nil
+	case "=~", "!~":
+		if _, err := regexp.Compile(valueToken.text); err != nil {
+			return false, valueToken.errorf("invalid regular expression: %s", err.Error())
+		}
+		filter.StringFilter = &analyticsdata.StringFilter{MatchType: "PARTIAL_REGEXP", Value: valueToken.text}
+		return operator.text == "!~", nil
+	default:
+		return false, operator.errorf("dimension %q cannot be compared with %s, use =, IN or LIKE", filter.FieldName, operator.text)
+	}
+}
+
+// likeFilter turns a LIKE pattern into the simplest matching string filter.
+// LIKE is case insensitive.
+func likeFilter(pattern string) *analyticsdata.StringFilter {
+	inner := strings.TrimSuffix(strings.TrimPrefix(pattern, "%"), "%")
+	if !strings.ContainsAny(inner, "%_") {
+		leading, trailing := strings.HasPrefix(pattern, "%"), len(pattern) > 1 && strings.HasSuffix(pattern, "%")
+		matchType := "EXACT"
+		switch {
+		case leading && trailing:
+			matchType = "CONTAINS"
+		case leading:
+			matchType = "ENDS_WITH"
+		case trailing:
+			matchType = "BEGINS_WITH"
+		}
+		return &analyticsdata.StringFilter{MatchType: matchType, Value: inner}
+	}
+
+	var b strings.Builder
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return &analyticsdata.StringFilter{MatchType: "FULL_REGEXP", Value: b.String()}
+}
+
+// applyRawQuery replaces the builder fields of a raw query with its parsed
+// statement. A leading time dimension of a time series query becomes the
+// time dimension.
+func applyRawQuery(queryModel *model.QueryModel) error {
+	if queryModel.EditorMode != model.EditorModeRaw {
+		return nil
+	}
+	statement, err := parseRawQuery(queryModel.RawQuery)
+	if err != nil {
+		return err
+	}
+	queryModel.Metrics = statement.metrics
+	queryModel.Dimensions = statement.dimensions
+	queryModel.TimeDimension = ""
+	if queryModel.Mode != model.TABLE && queryModel.Mode != model.REALTIME && len(statement.dimensions) > 0 && util.Contains(timeDimensions, statement.dimensions[0]) {
+		queryModel.TimeDimension = statement.dimensions[0]
+		queryModel.Dimensions = statement.dimensions[1:]
+	}
+	queryModel.DimensionFilter = statement.dimensionFilter
+	queryModel.MetricFilter = statement.metricFilter
+	queryModel.OrderBys = statement.orderBys
+	queryModel.Limit = statement.limit
+	return nil
+}
@@ -0,0 +1,126 @@
+package gav4
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+)
+
+func TestParseRawQuery(t *testing.T) {
+	statement, err := parseRawQuery(`SELECT sessions, activeUsers BY date, country
+WHERE country IN ('US','CA') AND sessions > 10 AND NOT pagePath LIKE '/blog%'
+ORDER BY sessions DESC, date LIMIT 20`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(statement.metrics, []string{"sessions", "activeUsers"}) {
+		t.Errorf("metrics = %v", statement.metrics)
+	}
+	if !reflect.DeepEqual(statement.dimensions, []string{"date", "country"}) {
+		t.Errorf("dimensions = %v", statement.dimensions)
+	}
+
+	dimensions := statement.dimensionFilter.AndGroup.Expressions
+	if len(dimensions) != 2 {
+		t.Fatalf("expected 2 dimension conditions, got %d", len(dimensions))
+	}
+	if in := dimensions[0].Filter.InListFilter; in == nil || !reflect.DeepEqual(in.Values, []string{"US", "CA"}) {
+		t.Errorf("IN filter = %+v", dimensions[0].Filter)
+	}
+	if like := dimensions[1].NotExpression.Filter.StringFilter; like.MatchType != "BEGINS_WITH" || like.Value != "/blog" {
+		t.Errorf("NOT LIKE filter = %+v", like)
+	}
+
+	numeric := statement.metricFilter.Filter.NumericFilter
+	if numeric.Operation != "GREATER_THAN" || numeric.Value.Int64Value != 10 {
+		t.Errorf("metric filter = %+v", numeric)
+	}
+
+	if len(statement.orderBys) != 2 || statement.orderBys[0].Metric.MetricName != "sessions" || !statement.orderBys[0].Desc || statement.orderBys[1].Dimension.DimensionName != "date" {
+		t.Errorf("orderBys = %+v", statement.orderBys)
+	}
+	if statement.limit != 20 {
+		t.Errorf("limit = %d", statement.limit)
+	}
+}
+
+func TestParseRawQuery_Or(t *testing.T) {
+	statement, err := parseRawQuery(`select eventCount by eventName where (eventName = 'it''s' or eventName =~ '^page') and eventCount between 1 and 2.5`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	or := statement.dimensionFilter.OrGroup.Expressions
+	if len(or) != 2 || or[0].Filter.StringFilter.Value != "it's" || or[1].Filter.StringFilter.MatchType != "PARTIAL_REGEXP" {
+		t.Errorf("OR group = %+v", statement.dimensionFilter.OrGroup)
+	}
+	between := statement.metricFilter.Filter.BetweenFilter
+	if between == nil || between.FromValue.Int64Value != 1 || between.ToValue.DoubleValue != 2.5 {
+		t.Errorf("BETWEEN filter = %+v", statement.metricFilter.Filter)
+	}
+}
+
+func TestParseRawQuery_Errors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"SELEKT sessions", "line 1, column 1: expected SELECT"},
+		{"SELECT sessions BY", "line 1, column 19: expected a field name, got end of query"},
+		{"SELECT sessions\nWHERE country = 'US", "line 2, column 17: unterminated string"},
+		{"SELECT sessions\n  WHERE sessions = 'many'", "line 2, column 20: metric \"sessions\" needs a number"},
+		{"SELECT sessions BY country WHERE country = 'US' OR sessions > 1", "column 52: dimensions and metrics cannot be combined with OR"},
+		{"SELECT sessions BY country WHERE country > 'US'", "column 42: dimension \"country\" cannot be compared"},
+		{"SELECT sessions ORDER BY country", "column 26: ORDER BY field \"country\" is not in SELECT or BY"},
+		{"SELECT sessions LIMIT 0", "column 23: LIMIT must be a whole number"},
+		{"SELECT sessions LIMIT 5 extra", "column 25: unexpected \"extra\""},
+		{"SELECT sessions WHERE country = 'US' # comment", "column 38: unexpected character \"#\""},
+	}
+	for _, tt := range tests {
+		_, err := parseRawQuery(tt.in)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseRawQuery(%q) error = %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestLikeFilter(t *testing.T) {
+	tests := []struct {
+		pattern, matchType, value string
+	}{
+		{"/blog", "EXACT", "/blog"},
+		{"/blog%", "BEGINS_WITH", "/blog"},
+		{"%.pdf", "ENDS_WITH", ".pdf"},
+		{"%sale%", "CONTAINS", "sale"},
+		{"/p_%.html", "FULL_REGEXP", `/p..*\.html`},
+	}
+	for _, tt := range tests {
+		f := likeFilter(tt.pattern)
+		if f.MatchType != tt.matchType || f.Value != tt.value {
+			t.Errorf("likeFilter(%q) = %s %q, want %s %q", tt.pattern, f.MatchType, f.Value, tt.matchType, tt.value)
+		}
+	}
+}
+
+func TestApplyRawQuery(t *testing.T) {
+	qm := &model.QueryModel{
+		Mode:       model.TIME_SERIES,
+		EditorMode: model.EditorModeRaw,
+		RawQuery:   "SELECT sessions BY date, country",
+		Metrics:    []string{"ignored"},
+	}
+	if err := applyRawQuery(qm); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if qm.TimeDimension != "date" || !reflect.DeepEqual(qm.Dimensions, []string{"country"}) || !reflect.DeepEqual(qm.Metrics, []string{"sessions"}) {
+		t.Errorf("query model = %+v", qm)
+	}
+
+	table := &model.QueryModel{Mode: model.TABLE, EditorMode: model.EditorModeRaw, RawQuery: "SELECT sessions BY date"}
+	if err := applyRawQuery(table); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if table.TimeDimension != "" || !reflect.DeepEqual(table.Dimensions, []string{"date"}) {
+		t.Errorf("table query model = %+v", table)
+	}
+}
@@ -0,0 +1,94 @@
+package gav4
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// reportCacheKey identifies a report by the credentials that fetched it, the
+// property and the RunReport request built from the query.
+type reportCacheKey struct {
+	Identity string                          `json:"identity"`
+	Property string                          `json:"property"`
+	Request  *analyticsdata.RunReportRequest `json:"request"`
+}
+
+func newReportCacheKey(client *GoogleClient, queryModel *model.QueryModel) (string, error) {
+	b, err := json.Marshal(reportCacheKey{
+		Identity: client.identity,
+		Property: queryModel.WebPropertyID,
+		Request:  newReportRequest(*queryModel),
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return newCacheKey(CacheNamespaceReports, client.identity, queryModel.WebPropertyID, hex.EncodeToString(sum[:])), nil
+}
+
+// reportCacheDuration returns how long the report of queryModel may be served
+// from the cache: the query's own duration, else the datasource default.
+// Realtime reports are never cached.
+func reportCacheDuration(config *setting.DatasourceSecretSettings, queryModel *model.QueryModel) time.Duration {
+	if queryModel.Mode == model.REALTIME {
+		return 0
+	}
+	if queryModel.CacheDurationSeconds != nil {
+		return time.Duration(*queryModel.CacheDurationSeconds) * time.Second
+	}
+	if config.DefaultCacheDurationSeconds > 0 {
+		return time.Duration(config.DefaultCacheDurationSeconds) * time.Second
+	}
+	return GaReportCacheDefaultDuration
+}
+
+// getCachedReport returns the report of queryModel from the cache when
+// present, and fetches and caches it for ttl otherwise. Every hit decodes a
+// fresh response, so the transforms may modify it.
+func (ga *GoogleAnalytics) getCachedReport(ctx context.Context, client *GoogleClient, queryModel *model.QueryModel, ttl time.Duration) (*analyticsdata.RunReportResponse, bool, error) {
+	if ttl <= 0 {
+		report, err := ga.getReport(ctx, client, queryModel)
+		return report, false, err
+	}
+	cacheKey, err := newReportCacheKey(client, queryModel)
+	if err != nil {
+		return nil, false, err
+	}
+	if report, found := cacheGet[*analyticsdata.RunReportResponse](ga.Cache, cacheKey); found {
+		return report, true, nil
+	}
+
+	report, err := ga.getReport(ctx, client, queryModel)
+	if err != nil {
+		return nil, false, err
+	}
+	cacheSet(ga.Cache, cacheKey, report, ttl)
+	return report, false, nil
+}
+
+// setCacheMeta records in the frame meta whether the frames were built from a
+// cached report.
+func setCacheMeta(frames data.Frames, hit bool) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		custom, ok := frame.Meta.Custom.(map[string]interface{})
+		if !ok {
+			if frame.Meta.Custom != nil {
+				continue
+			}
+			custom = map[string]interface{}{}
+		}
+		custom["cacheHit"] = hit
+		frame.Meta.Custom = custom
+	}
+}
@@ -0,0 +1,93 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestReportCacheDuration(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	tests := []struct {
+		name   string
+		config setting.DatasourceSecretSettings
+		query  model.QueryModel
+		want   time.Duration
+	}{
+		{"query duration", setting.DatasourceSecretSettings{DefaultCacheDurationSeconds: 60}, model.QueryModel{CacheDurationSeconds: seconds(30)}, 30 * time.Second},
+		{"disabled by query", setting.DatasourceSecretSettings{DefaultCacheDurationSeconds: 60}, model.QueryModel{CacheDurationSeconds: seconds(0)}, 0},
+		{"datasource default", setting.DatasourceSecretSettings{DefaultCacheDurationSeconds: 60}, model.QueryModel{}, time.Minute},
+		{"built-in default", setting.DatasourceSecretSettings{}, model.QueryModel{}, GaReportCacheDefaultDuration},
+		{"realtime", setting.DatasourceSecretSettings{}, model.QueryModel{Mode: model.REALTIME, CacheDurationSeconds: seconds(30)}, 0},
+	}
+	for _, tt := range tests {
+		if got := reportCacheDuration(&tt.config, &tt.query); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewReportCacheKey(t *testing.T) {
+	query := model.QueryModel{WebPropertyID: "properties/1", Metrics: []string{"sessions"}, StartDate: "2024-01-01", EndDate: "2024-01-02", RefID: "A"}
+	other := query
+	other.RefID = "B"
+
+	key := func(identity string, q model.QueryModel) string {
+		k, err := newReportCacheKey(&GoogleClient{identity: identity}, &q)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return k
+	}
+	if key("a@example.com", query) != key("a@example.com", other) {
+		t.Errorf("panels with the same request should share a cache entry")
+	}
+	if key("a@example.com", query) == key("b@example.com", query) {
+		t.Errorf("different credentials must not share a cache entry")
+	}
+	other.WebPropertyID = "properties/2"
+	if key("a@example.com", query) == key("a@example.com", other) {
+		t.Errorf("different properties must not share a cache entry")
+	}
+}
+
+func TestGetCachedReport_Hit(t *testing.T) {
+	ga := &GoogleAnalytics{Cache: NewMemoryCache(time.Minute, 0)}
+	client := &GoogleClient{identity: "a@example.com"}
+	query := &model.QueryModel{WebPropertyID: "properties/1", Metrics: []string{"sessions"}}
+
+	cacheKey, _ := newReportCacheKey(client, query)
+	b, _ := json.Marshal(&analyticsdata.RunReportResponse{RowCount: 7})
+	ga.Cache.Set(cacheKey, b, time.Minute)
+
+	report, hit, err := ga.getCachedReport(context.Background(), client, query, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !hit || report.RowCount != 7 {
+		t.Errorf("expected cached report, got hit=%v rowCount=%d", hit, report.RowCount)
+	}
+}
+
+func TestSetCacheMeta(t *testing.T) {
+	frames := data.Frames{
+		data.NewFrame("a").SetMeta(&data.FrameMeta{Custom: map[string]interface{}{"warnings": []string{}}}),
+		data.NewFrame("b"),
+	}
+	setCacheMeta(frames, true)
+	for _, frame := range frames {
+		custom := frame.Meta.Custom.(map[string]interface{})
+		if custom["cacheHit"] != true {
+			t.Errorf("frame %s: cacheHit = %v", frame.Name, custom["cacheHit"])
+		}
+	}
+	if _, ok := frames[0].Meta.Custom.(map[string]interface{})["warnings"]; !ok {
+		t.Errorf("existing custom meta must be kept")
+	}
+}
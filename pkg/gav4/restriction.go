@@ -0,0 +1,116 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// ErrNotAllowed is returned when a query or resource request reaches outside
+// the accounts and properties the datasource settings allow.
+var ErrNotAllowed = errors.New("not allowed by the datasource settings")
+
+func accountAllowed(config *setting.DatasourceSecretSettings, accountId string) bool {
+	if !config.Restricted() {
+		return true
+	}
+	if accountId != "" && !strings.HasPrefix(accountId, "accounts/") {
+		accountId = "accounts/" + accountId
+	}
+	return util.Contains(config.AllowedAccounts, accountId)
+}
+
+// checkProperty rejects properties outside the allowlist. Properties listed
+// directly are decided without a GA call; otherwise the property has to belong
+// to an allowed account, which is looked up in the cached account summaries.
+func (ga *GoogleAnalytics) checkProperty(ctx context.Context, config *setting.DatasourceSecretSettings, propertyId string) error {
+	if !config.Restricted() {
+		return nil
+	}
+	if propertyId != "" && !strings.HasPrefix(propertyId, "properties/") {
+		propertyId = "properties/" + propertyId
+	}
+	if util.Contains(config.AllowedProperties, propertyId) {
+		return nil
+	}
+	if len(config.AllowedAccounts) > 0 {
+		accountSummaries, err := ga.GetAccountSummaries(ctx, config)
+		if err != nil {
+			return err
+		}
+		for _, account := range accountSummaries {
+			for _, property := range account.PropertySummaries {
+				if property.Property == propertyId {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("property %q is %w", propertyId, ErrNotAllowed)
+}
+
+// restrictQueryModel checks every property of the query against the allowlist
+// and ANDs the enforced dimension filter into the query's own filter.
+func (ga *GoogleAnalytics) restrictQueryModel(ctx context.Context, config *setting.DatasourceSecretSettings, queryModel *model.QueryModel) error {
+	if queryModel.Mode == model.ANNOTATIONS && queryModel.WebPropertyID == "" && queryModel.AccountID != "" {
+		if !accountAllowed(config, queryModel.AccountID) {
+			return fmt.Errorf("account %q is %w", queryModel.AccountID, ErrNotAllowed)
+		}
+	}
+	for _, propertyId := range propertyIDs(queryModel) {
+		if err := ga.checkProperty(ctx, config, propertyId); err != nil {
+			return err
+		}
+	}
+	queryModel.DimensionFilter = enforceDimensionFilter(config, queryModel.DimensionFilter)
+	return nil
+}
+
+// enforceDimensionFilter returns filter ANDed with the datasource's enforced
+// dimension filter. The result is a new expression, filter is left untouched.
+func enforceDimensionFilter(config *setting.DatasourceSecretSettings, filter *analyticsdata.FilterExpression) *analyticsdata.FilterExpression {
+	if !filterHasContent(config.EnforcedDimensionFilter) {
+		return filter
+	}
+	if !filterHasContent(filter) {
+		return config.EnforcedDimensionFilter
+	}
+	return &analyticsdata.FilterExpression{
+		AndGroup: &analyticsdata.FilterExpressionList{
+			Expressions: []*analyticsdata.FilterExpression{config.EnforcedDimensionFilter, filter},
+		},
+	}
+}
+
+// filterAccountSummaries keeps the allowed accounts with all of their
+// properties, and the allowed properties of other accounts.
+func filterAccountSummaries(config *setting.DatasourceSecretSettings, accountSummaries []*model.AccountSummary) []*model.AccountSummary {
+	if !config.Restricted() {
+		return accountSummaries
+	}
+	accounts := make([]*model.AccountSummary, 0)
+	for _, account := range accountSummaries {
+		if util.Contains(config.AllowedAccounts, account.Account) {
+			accounts = append(accounts, account)
+			continue
+		}
+		properties := make([]*model.PropertySummary, 0)
+		for _, property := range account.PropertySummaries {
+			if util.Contains(config.AllowedProperties, property.Property) {
+				properties = append(properties, property)
+			}
+		}
+		if len(properties) > 0 {
+			filtered := *account
+			filtered.PropertySummaries = properties
+			accounts = append(accounts, &filtered)
+		}
+	}
+	return accounts
+}
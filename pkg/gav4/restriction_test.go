@@ -0,0 +1,86 @@
+package gav4
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+var hostFilter = &analyticsdata.FilterExpression{
+	Filter: &analyticsdata.Filter{
+		FieldName:    "hostName",
+		StringFilter: &analyticsdata.StringFilter{MatchType: "EXACT", Value: "shop.example.com"},
+	},
+}
+
+func TestRestrictQueryModel(t *testing.T) {
+	ga := &GoogleAnalytics{Cache: NewMemoryCache(time.Minute, 0)}
+	config := &setting.DatasourceSecretSettings{
+		AllowedProperties:       []string{"properties/1", "properties/2"},
+		EnforcedDimensionFilter: hostFilter,
+	}
+
+	queryModel := &model.QueryModel{WebPropertyID: "properties/1", WebPropertyIDs: []string{"properties/2"}}
+	if err := ga.restrictQueryModel(context.Background(), config, queryModel); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queryModel.DimensionFilter != hostFilter {
+		t.Errorf("enforced filter should be used as is when the query has none, got %+v", queryModel.DimensionFilter)
+	}
+
+	queryModel = &model.QueryModel{WebPropertyID: "properties/1", WebPropertyIDs: []string{"properties/3"}}
+	if err := ga.restrictQueryModel(context.Background(), config, queryModel); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected ErrNotAllowed for properties/3, got %v", err)
+	}
+
+	queryModel = &model.QueryModel{Mode: model.ANNOTATIONS, AccountID: "accounts/9"}
+	if err := ga.restrictQueryModel(context.Background(), config, queryModel); !errors.Is(err, ErrNotAllowed) {
+		t.Errorf("expected ErrNotAllowed for account change history, got %v", err)
+	}
+}
+
+func TestEnforceDimensionFilter(t *testing.T) {
+	config := &setting.DatasourceSecretSettings{EnforcedDimensionFilter: hostFilter}
+	own := &analyticsdata.FilterExpression{
+		NotExpression: &analyticsdata.FilterExpression{Filter: &analyticsdata.Filter{FieldName: "country"}},
+	}
+
+	got := enforceDimensionFilter(config, own)
+	if got.AndGroup == nil || len(got.AndGroup.Expressions) != 2 || got.AndGroup.Expressions[0] != hostFilter || got.AndGroup.Expressions[1] != own {
+		t.Errorf("expected hostFilter AND own filter, got %+v", got)
+	}
+	if got := enforceDimensionFilter(&setting.DatasourceSecretSettings{}, own); got != own {
+		t.Errorf("filter must be untouched without an enforced filter")
+	}
+}
+
+func TestFilterAccountSummaries(t *testing.T) {
+	summaries := []*model.AccountSummary{
+		{Account: "accounts/1", PropertySummaries: []*model.PropertySummary{{Property: "properties/10"}, {Property: "properties/11"}}},
+		{Account: "accounts/2", PropertySummaries: []*model.PropertySummary{{Property: "properties/20"}, {Property: "properties/21"}}},
+		{Account: "accounts/3", PropertySummaries: []*model.PropertySummary{{Property: "properties/30"}}},
+	}
+	config := &setting.DatasourceSecretSettings{
+		AllowedAccounts:   []string{"accounts/1"},
+		AllowedProperties: []string{"properties/21"},
+	}
+
+	got := filterAccountSummaries(config, summaries)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 accounts, got %d", len(got))
+	}
+	if len(got[0].PropertySummaries) != 2 {
+		t.Errorf("allowed account should keep all properties, got %d", len(got[0].PropertySummaries))
+	}
+	if len(got[1].PropertySummaries) != 1 || got[1].PropertySummaries[0].Property != "properties/21" {
+		t.Errorf("unexpected properties %+v", got[1].PropertySummaries)
+	}
+	if len(summaries[1].PropertySummaries) != 2 {
+		t.Errorf("cached summaries must not be modified")
+	}
+}
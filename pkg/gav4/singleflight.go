@@ -0,0 +1,64 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/util"
+	"golang.org/x/sync/singleflight"
+)
+
+// inflight collapses identical GA calls running at the same time, e.g. when
+// many viewers open the same dashboard. It is shared by every client, so keys
+// always carry the client identity.
+var inflight singleflight.Group
+
+// dedupe runs fn once for all concurrent callers with the same key. shared
+// reports whether the result was handed to more than one caller. A caller
+// whose ctx ends stops waiting, while the call carries on for the others, so
+// fn must not depend on the ctx of any one caller, see flightContext.
+func dedupe[T any](ctx context.Context, client *GoogleClient, key string, fn func() (T, error)) (T, bool, error) {
+	var zero T
+	ch := inflight.DoChan(client.identity+"|"+key, func() (interface{}, error) {
+		return fn()
+	})
+	select {
+	case <-ctx.Done():
+		return zero, false, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return zero, res.Shared, res.Err
+		}
+		return res.Val.(T), res.Shared, nil
+	}
+}
+
+// flightContext is the context of a call shared by several callers: it keeps
+// the values of ctx, such as the trace, but not its cancellation.
+func flightContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
+
+// requestKey renders a request as a dedupe key
+func requestKey(method string, property string, req interface{}) string {
+	b, err := json.Marshal(req)
+	if err != nil {
+		// unkeyable requests just are not collapsed
+		return ""
+	}
+	return method + "|" + property + "|" + string(b)
+}
+
+// dedupeCopy is dedupe for results the caller modifies: a shared result is
+// copied, so that every caller gets its own.
+func dedupeCopy[T any](ctx context.Context, client *GoogleClient, key string, fn func() (*T, error)) (*T, error) {
+	if key == "" {
+		return fn()
+	}
+	v, shared, err := dedupe(ctx, client, key, fn)
+	if err != nil || !shared {
+		return v, err
+	}
+	return util.TypeConverter[T](v)
+}
@@ -0,0 +1,106 @@
+package gav4
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+func TestDedupeCopy_CollapsesConcurrentCalls(t *testing.T) {
+	client := &GoogleClient{identity: "a@example.com"}
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (*analyticsdata.RunReportResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &analyticsdata.RunReportResponse{RowCount: 3}, nil
+	}
+
+	const callers = 5
+	reports := make([]*analyticsdata.RunReportResponse, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report, err := dedupeCopy(context.Background(), client, "runReport|properties/1|{}", fetch)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			reports[i] = report
+		}(i)
+	}
+	// give every caller time to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls)
+	}
+	for i, report := range reports {
+		if report == nil || report.RowCount != 3 {
+			t.Fatalf("caller %d got %+v", i, report)
+		}
+		for j := 0; j < i; j++ {
+			if reports[j] == report {
+				t.Errorf("callers %d and %d share a response", i, j)
+			}
+		}
+	}
+}
+
+func TestDedupe_KeyedByIdentity(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	fetch := func() (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 0, nil
+	}
+
+	var wg sync.WaitGroup
+	for _, identity := range []string{"a@example.com", "b@example.com"} {
+		wg.Add(1)
+		go func(client *GoogleClient) {
+			defer wg.Done()
+			_, _, _ = dedupe(context.Background(), client, "metadata|properties/1", fetch)
+		}(&GoogleClient{identity: identity})
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 2 {
+		t.Errorf("different credentials must not share a call, got %d calls", calls)
+	}
+}
+
+func TestDedupe_CallerCancelled(t *testing.T) {
+	client := &GoogleClient{identity: "cancel@example.com"}
+	release := make(chan struct{})
+	done := make(chan struct{})
+	fetch := func() (int, error) {
+		<-release
+		close(done)
+		return 1, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := dedupe(ctx, client, "metadata|properties/1", fetch); err != context.Canceled {
+		t.Errorf("cancelled caller err = %v, want context.Canceled", err)
+	}
+
+	// the call carries on for the callers still waiting
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("shared call did not complete")
+	}
+}
@@ -0,0 +1,148 @@
+package gav4
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// realtimeStreamKey holds the parts of a realtime query that decide what a
+// poller fetches. The refId and absolute time range are left out so that
+// panels on different dashboards end up on the same channel.
+type realtimeStreamKey struct {
+	WebPropertyID   string                          `json:"webPropertyId"`
+	Metrics         []string                        `json:"metrics"`
+	Dimensions      []string                        `json:"dimensions"`
+	DimensionFilter *analyticsdata.FilterExpression `json:"dimensionFilter,omitempty"`
+	MetricFilter    *analyticsdata.FilterExpression `json:"metricFilter,omitempty"`
+	MinuteRanges    []*analyticsdata.MinuteRange    `json:"minuteRanges"`
+	Interval        time.Duration                   `json:"interval"`
+}
+
+// RealtimeStreamPath returns the Grafana Live channel path for a streaming
+// realtime query. Identical queries get the same path, so Grafana runs a
+// single RunStream poller for all of their subscribers.
+func RealtimeStreamPath(queryModel *model.QueryModel) (string, error) {
+	if len(queryModel.WebPropertyIDs) > 1 {
+		return "", fmt.Errorf("streaming supports a single property, got %d", len(queryModel.WebPropertyIDs))
+	}
+	minuteRanges, err := realtimeMinuteRanges(*queryModel)
+	if err != nil {
+		return "", err
+	}
+	key := realtimeStreamKey{
+		WebPropertyID: queryModel.WebPropertyID,
+		Metrics:       queryModel.Metrics,
+		Dimensions:    queryModel.Dimensions,
+		MinuteRanges:  minuteRanges,
+		Interval:      RealtimeStreamInterval(queryModel),
+	}
+	if filterHasContent(queryModel.DimensionFilter) {
+		key.DimensionFilter = queryModel.DimensionFilter
+	}
+	if filterHasContent(queryModel.MetricFilter) {
+		key.MetricFilter = queryModel.MetricFilter
+	}
+	b, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return "realtime/" + hex.EncodeToString(sum[:16]), nil
+}
+
+// RealtimeStreamInterval returns how often a streaming realtime query polls GA.
+func RealtimeStreamInterval(queryModel *model.QueryModel) time.Duration {
+	interval := time.Duration(queryModel.StreamInterval) * time.Second
+	if interval <= 0 {
+		return GaRealTimeStreamDefaultInterval
+	}
+	if interval < GaRealTimeStreamMinInterval {
+		return GaRealTimeStreamMinInterval
+	}
+	return interval
+}
+
+// RunRealtimeStream polls RunRealtimeReport for query and pushes every result
+// to sender until ctx is done. A failed poll is logged and retried on the next
+// tick so that a transient GA error does not close the channel.
+func (ga *GoogleAnalytics) RunRealtimeStream(ctx context.Context, config *setting.DatasourceSecretSettings, query backend.DataQuery, sender *backend.StreamSender) error {
+	queryModel, err := GetQueryModel(query)
+	if err != nil {
+		return fmt.Errorf("failed to read query: %w", err)
+	}
+	if err := ga.restrictQueryModel(ctx, config, queryModel); err != nil {
+		return err
+	}
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		log.DefaultLogger.Error("RunRealtimeStream: Fail NewGoogleClient", "error", err.Error())
+		return err
+	}
+
+	// Keep the window relative to now: "last 30 minutes" stays the last 30 minutes.
+	fromAgo := time.Since(queryModel.From)
+	toAgo := time.Since(queryModel.To)
+
+	ticker := time.NewTicker(RealtimeStreamInterval(queryModel))
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		queryModel.From = now.Add(-fromAgo)
+		queryModel.To = now.Add(-toAgo)
+
+		frames, err := ga.getRealtimeStreamFrames(ctx, client, queryModel, now)
+		if err != nil {
+			log.DefaultLogger.Warn("RunRealtimeStream: poll failed", "error", err)
+		}
+		for _, frame := range frames {
+			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ga *GoogleAnalytics) getRealtimeStreamFrames(ctx context.Context, client *GoogleClient, queryModel *model.QueryModel, now time.Time) (data.Frames, error) {
+	report, err := ga.getReport(ctx, client, queryModel)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyCalculatedFields(report, queryModel.CalculatedFields); err != nil {
+		return nil, err
+	}
+	frames, err := transformReportsResponseToDataFrames(ctx, report, queryModel.RefID, queryModel.Timezone, model.REALTIME, queryModel.From, queryModel.To)
+	if err != nil {
+		return nil, err
+	}
+	for _, frame := range *frames {
+		prependTimeField(frame, now)
+	}
+	return *frames, nil
+}
+
+// prependTimeField stamps every row of a realtime table frame with the poll
+// time, so that streamed frames append into a time series on the panel.
+func prependTimeField(frame *data.Frame, t time.Time) {
+	times := make([]time.Time, frame.Rows())
+	for i := range times {
+		times[i] = t
+	}
+	frame.Fields = append([]*data.Field{data.NewField("time", nil, times)}, frame.Fields...)
+}
@@ -0,0 +1,72 @@
+package gav4
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestRealtimeStreamPath_SharedAcrossPanels(t *testing.T) {
+	// Two dashboards asking for the same realtime data at different moments
+	// and with different refIds must land on the same channel.
+	now := time.Now()
+	a := &model.QueryModel{RefID: "A", WebPropertyID: "properties/1", Metrics: []string{"activeUsers"}, From: now.Add(-30 * time.Minute), To: now}
+	b := &model.QueryModel{RefID: "B", WebPropertyID: "properties/1", Metrics: []string{"activeUsers"}, From: now.Add(-30*time.Minute - 10*time.Second), To: now.Add(-10 * time.Second)}
+
+	pathA, err := RealtimeStreamPath(a)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pathB, err := RealtimeStreamPath(b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pathA != pathB {
+		t.Errorf("identical queries got different paths: %q, %q", pathA, pathB)
+	}
+	if !strings.HasPrefix(pathA, "realtime/") {
+		t.Errorf("path = %q, want realtime/ prefix", pathA)
+	}
+
+	c := *a
+	c.Metrics = []string{"eventCount"}
+	pathC, _ := RealtimeStreamPath(&c)
+	if pathC == pathA {
+		t.Errorf("different metrics must not share a path")
+	}
+}
+
+func TestRealtimeStreamInterval(t *testing.T) {
+	tests := []struct {
+		seconds int64
+		want    time.Duration
+	}{
+		{0, GaRealTimeStreamDefaultInterval},
+		{1, GaRealTimeStreamMinInterval},
+		{30, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := RealtimeStreamInterval(&model.QueryModel{StreamInterval: tt.seconds}); got != tt.want {
+			t.Errorf("RealtimeStreamInterval(%d) = %s, want %s", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+func TestPrependTimeField(t *testing.T) {
+	frame := data.NewFrame("A", data.NewField("activeUsers", nil, []float64{1, 2}))
+	now := time.Date(2024, 9, 12, 10, 0, 0, 0, time.UTC)
+
+	prependTimeField(frame, now)
+
+	if len(frame.Fields) != 2 || frame.Fields[0].Name != "time" {
+		t.Fatalf("expected time field first, got %v", frame.Fields)
+	}
+	for i := 0; i < frame.Rows(); i++ {
+		if got := frame.Fields[0].At(i).(time.Time); !got.Equal(now) {
+			t.Errorf("row %d time = %s, want %s", i, got, now)
+		}
+	}
+}
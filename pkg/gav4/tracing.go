@@ -0,0 +1,33 @@
+package gav4
+
+import (
+	"strings"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Span attributes, all spans of a query carry the queryAttributes
+const (
+	attributePropertyID = attribute.Key("ga.property_id")
+	attributeMode       = attribute.Key("ga.mode")
+	attributeMetrics    = attribute.Key("ga.metrics")
+	attributeDimensions = attribute.Key("ga.dimensions")
+	attributePages      = attribute.Key("ga.pages")
+	attributeRows       = attribute.Key("ga.rows")
+	attributeFrames     = attribute.Key("ga.frames")
+	attributeCacheHit   = attribute.Key("ga.cache_hit")
+)
+
+func queryAttributes(query model.QueryModel) []attribute.KeyValue {
+	property := query.WebPropertyID
+	if len(query.WebPropertyIDs) > 1 {
+		property = strings.Join(query.WebPropertyIDs, ",")
+	}
+	return []attribute.KeyValue{
+		attributePropertyID.String(property),
+		attributeMode.String(string(query.Mode)),
+		attributeMetrics.Int(len(query.Metrics)),
+		attributeDimensions.Int(len(query.Dimensions)),
+	}
+}
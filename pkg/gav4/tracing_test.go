@@ -0,0 +1,149 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+	"google.golang.org/api/option"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := tracing.DefaultTracer()
+	tracing.InitDefaultTracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test"))
+	t.Cleanup(func() { tracing.InitDefaultTracer(previous) })
+	return recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestGetReportSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(analyticsdata.RunReportResponse{
+			RowCount: GaReportMaxResult + 1,
+			Rows:     []*analyticsdata.Row{{MetricValues: []*analyticsdata.MetricValue{{Value: "1"}}}},
+		})
+	}))
+	defer server.Close()
+	service, err := analyticsdata.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &GoogleClient{analyticsdata: service, identity: "tracing-test"}
+
+	query := model.QueryModel{WebPropertyID: "properties/9", Mode: model.TABLE, Metrics: []string{"sessions"}, Dimensions: []string{"country", "city"}, StartDate: "7daysAgo", EndDate: "today"}
+	if _, err := client.getReport(context.Background(), query); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "GoogleClient.getReport" {
+		t.Fatalf("spans = %v", spans)
+	}
+	attributes := spanAttributes(spans[0])
+	if attributes[attributePropertyID].AsString() != "properties/9" || attributes[attributeMode].AsString() != "table" {
+		t.Errorf("query attributes = %v", attributes)
+	}
+	if attributes[attributeMetrics].AsInt64() != 1 || attributes[attributeDimensions].AsInt64() != 2 {
+		t.Errorf("count attributes = %v", attributes)
+	}
+	if attributes[attributePages].AsInt64() != 2 || attributes[attributeRows].AsInt64() != 2 {
+		t.Errorf("pages and rows = %v", attributes)
+	}
+}
+
+func TestGetReport_SharedAfterCancel(t *testing.T) {
+	recorder := recordSpans(t)
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		_ = json.NewEncoder(w).Encode(analyticsdata.RunReportResponse{
+			RowCount: 1,
+			Rows:     []*analyticsdata.Row{{MetricValues: []*analyticsdata.MetricValue{{Value: "1"}}}},
+		})
+	}))
+	defer server.Close()
+	service, err := analyticsdata.NewService(context.Background(), option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &GoogleClient{analyticsdata: service, identity: "cancel-test"}
+	query := model.QueryModel{WebPropertyID: "properties/9", Mode: model.TABLE, Metrics: []string{"sessions"}, StartDate: "7daysAgo", EndDate: "today"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := client.getReport(ctx, query)
+		leader <- err
+	}()
+	<-started
+
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := client.getReport(context.Background(), query)
+		waiter <- err
+	}()
+	// give the waiter time to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	// the cancelled caller returns while the call is still running
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled caller err = %v, want context.Canceled", err)
+	}
+	close(release)
+
+	if err := <-waiter; err != nil {
+		t.Errorf("waiter failed after the first caller was cancelled: %v", err)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %v", spans)
+	}
+	pages := 0
+	for _, span := range spans {
+		pages += int(spanAttributes(span)[attributePages].AsInt64())
+	}
+	if pages != 1 {
+		t.Errorf("pages = %d, want 1 on the waiter's own span", pages)
+	}
+}
+
+func TestTransformSpan(t *testing.T) {
+	recorder := recordSpans(t)
+	report := &analyticsdata.RunReportResponse{
+		MetricHeaders: []*analyticsdata.MetricHeader{{Name: "sessions", Type: "TYPE_INTEGER"}},
+		Rows:          []*analyticsdata.Row{{MetricValues: []*analyticsdata.MetricValue{{Value: "3"}}}},
+	}
+	if _, err := transformReportsResponseToDataFrames(context.Background(), report, "A", "UTC", model.TABLE, time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans = %v", spans)
+	}
+	attributes := spanAttributes(spans[0])
+	if attributes[attributeRows].AsInt64() != 1 || attributes[attributeFrames].AsInt64() != 1 {
+		t.Errorf("attributes = %v", attributes)
+	}
+}
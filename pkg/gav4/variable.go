@@ -0,0 +1,213 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+)
+
+// GetVariableValues answers a template variable query with text/value pairs
+func (ga *GoogleAnalytics) GetVariableValues(ctx context.Context, config *setting.DatasourceSecretSettings, query model.VariableQuery) ([]model.MetricFindValue, error) {
+	switch query.Type {
+	case model.VariableQueryAccounts:
+		return ga.getAccountVariableValues(ctx, config)
+	case model.VariableQueryProperties:
+		return ga.getPropertyVariableValues(ctx, config, query.AccountID)
+	case model.VariableQueryDataStreams:
+		return ga.getDataStreamVariableValues(ctx, config, query.WebPropertyID)
+	case model.VariableQueryDimensionValues:
+		return ga.getDimensionVariableValues(ctx, config, query)
+	default:
+		return nil, fmt.Errorf("unknown variable query type %q", query.Type)
+	}
+}
+
+func (ga *GoogleAnalytics) getAccountVariableValues(ctx context.Context, config *setting.DatasourceSecretSettings) ([]model.MetricFindValue, error) {
+	accountSummaries, err := ga.GetAccountSummaries(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]model.MetricFindValue, 0, len(accountSummaries))
+	for _, account := range accountSummaries {
+		values = append(values, model.MetricFindValue{Text: account.DisplayName, Value: account.Account})
+	}
+	return values, nil
+}
+
+func (ga *GoogleAnalytics) getPropertyVariableValues(ctx context.Context, config *setting.DatasourceSecretSettings, accountId string) ([]model.MetricFindValue, error) {
+	accountSummaries, err := ga.GetAccountSummaries(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	if accountId != "" && !strings.HasPrefix(accountId, "accounts/") {
+		accountId = "accounts/" + accountId
+	}
+	values := make([]model.MetricFindValue, 0)
+	for _, account := range accountSummaries {
+		if accountId != "" && account.Account != accountId {
+			continue
+		}
+		for _, property := range account.PropertySummaries {
+			values = append(values, model.MetricFindValue{Text: property.DisplayName, Value: property.Property})
+		}
+	}
+	return values, nil
+}
+
+func (ga *GoogleAnalytics) getDataStreamVariableValues(ctx context.Context, config *setting.DatasourceSecretSettings, webPropertyId string) ([]model.MetricFindValue, error) {
+	if webPropertyId == "" {
+		return nil, fmt.Errorf("required webpropertyid")
+	}
+	if err := ga.checkProperty(ctx, config, webPropertyId); err != nil {
+		return nil, err
+	}
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := newCacheKey(CacheNamespaceVariables, fingerprint, webPropertyId, "dataStreams")
+	if item, found := cacheGet[[]model.MetricFindValue](ga.Cache, cacheKey); found {
+		return item, nil
+	}
+
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google API client: %w", err)
+	}
+	dataStreams, err := client.getDataStreams(webPropertyId, "")
+	if err != nil {
+		return nil, err
+	}
+	values := make([]model.MetricFindValue, 0, len(dataStreams))
+	for _, dataStream := range dataStreams {
+		// The streamId dimension holds the last segment of properties/x/dataStreams/y
+		values = append(values, model.MetricFindValue{Text: dataStream.DisplayName, Value: path.Base(dataStream.Name)})
+	}
+
+	cacheSet(ga.Cache, cacheKey, values, 60*time.Second)
+	return values, nil
+}
+
+func (ga *GoogleAnalytics) getDimensionVariableValues(ctx context.Context, config *setting.DatasourceSecretSettings, query model.VariableQuery) ([]model.MetricFindValue, error) {
+	if query.WebPropertyID == "" {
+		return nil, fmt.Errorf("required webpropertyid")
+	}
+	if query.Dimension == "" {
+		return nil, fmt.Errorf("required dimension")
+	}
+	if query.StartDate == "" {
+		query.StartDate = "30daysAgo"
+	}
+	if query.EndDate == "" {
+		query.EndDate = "today"
+	}
+	if query.Limit <= 0 || query.Limit > GaVariableMaxResult {
+		query.Limit = GaVariableMaxResult
+	}
+	if err := ga.checkProperty(ctx, config, query.WebPropertyID); err != nil {
+		return nil, err
+	}
+	query.Filter = enforceDimensionFilter(config, query.Filter)
+
+	filter, err := json.Marshal(query.Filter)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := newCacheKey(CacheNamespaceVariables, fingerprint, query.WebPropertyID, "dimension", query.Dimension, "values", fmt.Sprintf("%s:%s:%d:%s", query.StartDate, query.EndDate, query.Limit, filter))
+	if item, found := cacheGet[[]model.MetricFindValue](ga.Cache, cacheKey); found {
+		return item, nil
+	}
+
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google API client: %w", err)
+	}
+	dimensionValues, err := client.getDimensionValues(query.WebPropertyID, query.Dimension, query.StartDate, query.EndDate, query.Filter, "", query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]model.MetricFindValue, 0, len(dimensionValues))
+	for _, value := range dimensionValues {
+		values = append(values, model.MetricFindValue{Text: value, Value: value})
+	}
+
+	cacheSet(ga.Cache, cacheKey, values, 60*time.Second)
+	return values, nil
+}
+
+// GetDimensionValueSuggestions returns the most frequent values of a dimension
+// over the last days that match prefix, for autocomplete in the filter editor.
+func (ga *GoogleAnalytics) GetDimensionValueSuggestions(ctx context.Context, config *setting.DatasourceSecretSettings, webPropertyId string, dimension string, prefix string, matchType string, days int64, limit int64) ([]string, error) {
+	if webPropertyId == "" {
+		return nil, fmt.Errorf("required webpropertyid")
+	}
+	if dimension == "" {
+		return nil, fmt.Errorf("required dimension")
+	}
+	switch matchType {
+	case "":
+		matchType = "BEGINS_WITH"
+	case "BEGINS_WITH", "CONTAINS":
+	default:
+		return nil, fmt.Errorf("unsupported match type %q, use BEGINS_WITH or CONTAINS", matchType)
+	}
+	if days <= 0 {
+		days = GaSuggestionDefaultDays
+	}
+	if limit <= 0 {
+		limit = GaSuggestionDefaultLimit
+	}
+	if limit > GaVariableMaxResult {
+		limit = GaVariableMaxResult
+	}
+	if err := ga.checkProperty(ctx, config, webPropertyId); err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := newCacheKey(CacheNamespaceVariables, fingerprint, webPropertyId, "dimension", dimension, "suggestions", fmt.Sprintf("%s:%s:%d:%d", matchType, prefix, days, limit))
+	if item, found := cacheGet[[]string](ga.Cache, cacheKey); found {
+		return item, nil
+	}
+
+	var filter *analyticsdata.FilterExpression
+	if prefix != "" {
+		filter = &analyticsdata.FilterExpression{
+			Filter: &analyticsdata.Filter{
+				FieldName: dimension,
+				StringFilter: &analyticsdata.StringFilter{
+					MatchType: matchType,
+					Value:     prefix,
+				},
+			},
+		}
+	}
+	filter = enforceDimensionFilter(config, filter)
+
+	client, err := ga.googleClient(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google API client: %w", err)
+	}
+	startDate := fmt.Sprintf("%ddaysAgo", days)
+	values, err := client.getDimensionValues(webPropertyId, dimension, startDate, "today", filter, "eventCount", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSet(ga.Cache, cacheKey, values, 5*time.Minute)
+	return values, nil
+}
@@ -0,0 +1,413 @@
+package gav4
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	analyticsadmin "google.golang.org/api/analyticsadmin/v1beta"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
+	"google.golang.org/api/option"
+)
+
+// fakeGA answers the Admin and Data API calls of variable queries and
+// records the paths and report requests it was sent.
+type fakeGA struct {
+	mu      sync.Mutex
+	paths   []string
+	reports []analyticsdata.RunReportRequest
+}
+
+func (f *fakeGA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths = append(f.paths, r.URL.Path)
+	switch {
+	case r.URL.Path == "/v1beta/accountSummaries":
+		_ = json.NewEncoder(w).Encode(analyticsadmin.GoogleAnalyticsAdminV1betaListAccountSummariesResponse{
+			AccountSummaries: []*analyticsadmin.GoogleAnalyticsAdminV1betaAccountSummary{
+				{Account: "accounts/1", DisplayName: "Shop", PropertySummaries: []*analyticsadmin.GoogleAnalyticsAdminV1betaPropertySummary{
+					{Property: "properties/10", DisplayName: "Shop web"},
+					{Property: "properties/11", DisplayName: "Shop app"},
+				}},
+				{Account: "accounts/2", DisplayName: "Blog", PropertySummaries: []*analyticsadmin.GoogleAnalyticsAdminV1betaPropertySummary{
+					{Property: "properties/20", DisplayName: "Blog web"},
+				}},
+			},
+		})
+	case r.URL.Path == "/v1beta/properties/10/dataStreams":
+		_ = json.NewEncoder(w).Encode(analyticsadmin.GoogleAnalyticsAdminV1betaListDataStreamsResponse{
+			DataStreams: []*analyticsadmin.GoogleAnalyticsAdminV1betaDataStream{
+				{Name: "properties/10/dataStreams/100", DisplayName: "Web"},
+				{Name: "properties/10/dataStreams/101", DisplayName: "iOS"},
+			},
+		})
+	case strings.HasSuffix(r.URL.Path, ":runReport"):
+		var req analyticsdata.RunReportRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.reports = append(f.reports, req)
+		_ = json.NewEncoder(w).Encode(analyticsdata.RunReportResponse{
+			Rows: []*analyticsdata.Row{
+				{DimensionValues: []*analyticsdata.DimensionValue{{Value: "US"}}},
+				{DimensionValues: []*analyticsdata.DimensionValue{{Value: "KR"}}},
+			},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGA) requests() ([]string, []analyticsdata.RunReportRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.paths...), append([]analyticsdata.RunReportRequest(nil), f.reports...)
+}
+
+// newFakeGoogleAnalytics returns a GoogleAnalytics whose clients call fake
+func newFakeGoogleAnalytics(t *testing.T, fake *fakeGA) *GoogleAnalytics {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &GoogleAnalytics{
+		Cache: NewMemoryCache(time.Minute, 0),
+		newClient: func(ctx context.Context, config *setting.DatasourceSecretSettings) (*GoogleClient, error) {
+			data, err := analyticsdata.NewService(ctx, option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+			if err != nil {
+				return nil, err
+			}
+			admin, err := analyticsadmin.NewService(ctx, option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+			if err != nil {
+				return nil, err
+			}
+			return &GoogleClient{analyticsdata: data, analyticsadmin: admin, identity: "variable-test"}, nil
+		},
+	}
+}
+
+// variableConfig resolves to a fingerprint without ever minting a token
+func variableConfig() *setting.DatasourceSecretSettings {
+	return &setting.DatasourceSecretSettings{ClientEmail: "sa@example.iam.gserviceaccount.com", TokenURI: "https://oauth2.googleapis.com/token", PrivateKey: "not a key"}
+}
+
+func TestGetVariableValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   model.VariableQuery
+		want    []model.MetricFindValue
+		wantErr string
+	}{
+		{
+			name:  "accounts",
+			query: model.VariableQuery{Type: model.VariableQueryAccounts},
+			want:  []model.MetricFindValue{{Text: "Shop", Value: "accounts/1"}, {Text: "Blog", Value: "accounts/2"}},
+		},
+		{
+			name:  "properties of every account",
+			query: model.VariableQuery{Type: model.VariableQueryProperties},
+			want:  []model.MetricFindValue{{Text: "Shop web", Value: "properties/10"}, {Text: "Shop app", Value: "properties/11"}, {Text: "Blog web", Value: "properties/20"}},
+		},
+		{
+			name:  "properties of an account by bare ID",
+			query: model.VariableQuery{Type: model.VariableQueryProperties, AccountID: "2"},
+			want:  []model.MetricFindValue{{Text: "Blog web", Value: "properties/20"}},
+		},
+		{
+			name:  "data streams by stream ID",
+			query: model.VariableQuery{Type: model.VariableQueryDataStreams, WebPropertyID: "properties/10"},
+			want:  []model.MetricFindValue{{Text: "Web", Value: "100"}, {Text: "iOS", Value: "101"}},
+		},
+		{
+			name:  "dimension values",
+			query: model.VariableQuery{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country"},
+			want:  []model.MetricFindValue{{Text: "US", Value: "US"}, {Text: "KR", Value: "KR"}},
+		},
+		{
+			name:    "data streams without property",
+			query:   model.VariableQuery{Type: model.VariableQueryDataStreams},
+			wantErr: "required webpropertyid",
+		},
+		{
+			name:    "dimension values without dimension",
+			query:   model.VariableQuery{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10"},
+			wantErr: "required dimension",
+		},
+		{
+			name:    "unknown type",
+			query:   model.VariableQuery{Type: "metrics"},
+			wantErr: `unknown variable query type "metrics"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ga := newFakeGoogleAnalytics(t, &fakeGA{})
+			got, err := ga.GetVariableValues(context.Background(), variableConfig(), tt.query)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("values = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetVariableValues_DimensionValuesRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		query model.VariableQuery
+		want  analyticsdata.RunReportRequest
+	}{
+		{
+			name:  "defaults",
+			query: model.VariableQuery{Dimension: "country"},
+			want: analyticsdata.RunReportRequest{
+				DateRanges: []*analyticsdata.DateRange{{StartDate: "30daysAgo", EndDate: "today"}},
+				Dimensions: []*analyticsdata.Dimension{{Name: "country"}},
+				OrderBys:   []*analyticsdata.OrderBy{{Dimension: &analyticsdata.DimensionOrderBy{DimensionName: "country"}}},
+				Limit:      GaVariableMaxResult,
+			},
+		},
+		{
+			name:  "dates, limit and filter",
+			query: model.VariableQuery{Dimension: "city", StartDate: "2024-01-01", EndDate: "yesterday", Limit: 5, Filter: hostFilter},
+			want: analyticsdata.RunReportRequest{
+				DateRanges:      []*analyticsdata.DateRange{{StartDate: "2024-01-01", EndDate: "yesterday"}},
+				Dimensions:      []*analyticsdata.Dimension{{Name: "city"}},
+				OrderBys:        []*analyticsdata.OrderBy{{Dimension: &analyticsdata.DimensionOrderBy{DimensionName: "city"}}},
+				Limit:           5,
+				DimensionFilter: hostFilter,
+			},
+		},
+		{
+			name:  "limit above the maximum",
+			query: model.VariableQuery{Dimension: "country", Limit: GaVariableMaxResult + 1},
+			want: analyticsdata.RunReportRequest{
+				DateRanges: []*analyticsdata.DateRange{{StartDate: "30daysAgo", EndDate: "today"}},
+				Dimensions: []*analyticsdata.Dimension{{Name: "country"}},
+				OrderBys:   []*analyticsdata.OrderBy{{Dimension: &analyticsdata.DimensionOrderBy{DimensionName: "country"}}},
+				Limit:      GaVariableMaxResult,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGA{}
+			ga := newFakeGoogleAnalytics(t, fake)
+			tt.query.Type = model.VariableQueryDimensionValues
+			tt.query.WebPropertyID = "properties/10"
+			if _, err := ga.GetVariableValues(context.Background(), variableConfig(), tt.query); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			paths, reports := fake.requests()
+			if len(paths) != 1 || paths[0] != "/v1beta/properties/10:runReport" {
+				t.Fatalf("paths = %v", paths)
+			}
+			if !reflect.DeepEqual(reports[0], tt.want) {
+				got, _ := json.Marshal(reports[0])
+				want, _ := json.Marshal(tt.want)
+				t.Errorf("request = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGetVariableValues_Cache(t *testing.T) {
+	fake := &fakeGA{}
+	ga := newFakeGoogleAnalytics(t, fake)
+	config := variableConfig()
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streams := model.VariableQuery{Type: model.VariableQueryDataStreams, WebPropertyID: "properties/10"}
+	countries := model.VariableQuery{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country"}
+	for _, query := range []model.VariableQuery{streams, countries, streams, countries} {
+		if _, err := ga.GetVariableValues(context.Background(), config, query); err != nil {
+			t.Fatalf("%s: unexpected error: %v", query.Type, err)
+		}
+	}
+	if paths, _ := fake.requests(); len(paths) != 2 {
+		t.Errorf("repeated queries must be answered from the cache, GA was called for %v", paths)
+	}
+
+	for _, key := range []string{
+		newCacheKey(CacheNamespaceVariables, fingerprint, "properties/10", "dataStreams"),
+		newCacheKey(CacheNamespaceVariables, fingerprint, "properties/10", "dimension", "country", "values", "30daysAgo:today:1000:null"),
+	} {
+		if _, found := ga.Cache.Get(key); !found {
+			t.Errorf("no cache entry %q", key)
+		}
+	}
+
+	// every part of the key tells queries apart
+	for _, query := range []model.VariableQuery{
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "city"},
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country", StartDate: "7daysAgo"},
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country", EndDate: "yesterday"},
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country", Limit: 10},
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "country", Filter: hostFilter},
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/11", Dimension: "country"},
+	} {
+		before, _ := fake.requests()
+		if _, err := ga.GetVariableValues(context.Background(), config, query); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if after, _ := fake.requests(); len(after) != len(before)+1 {
+			t.Errorf("query %+v was answered from another query's cache entry", query)
+		}
+	}
+}
+
+func TestGetVariableValues_EnforcedFilter(t *testing.T) {
+	fake := &fakeGA{}
+	ga := newFakeGoogleAnalytics(t, fake)
+	config := variableConfig()
+	config.AllowedProperties = []string{"properties/10"}
+	config.EnforcedDimensionFilter = hostFilter
+	own := &analyticsdata.FilterExpression{
+		Filter: &analyticsdata.Filter{FieldName: "country", StringFilter: &analyticsdata.StringFilter{MatchType: "EXACT", Value: "US"}},
+	}
+
+	query := model.VariableQuery{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/10", Dimension: "city", Filter: own}
+	if _, err := ga.GetVariableValues(context.Background(), config, query); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, reports := fake.requests()
+	filter := reports[0].DimensionFilter
+	if filter == nil || filter.AndGroup == nil || len(filter.AndGroup.Expressions) != 2 ||
+		!reflect.DeepEqual(filter.AndGroup.Expressions[0], hostFilter) || !reflect.DeepEqual(filter.AndGroup.Expressions[1], own) {
+		got, _ := json.Marshal(filter)
+		t.Errorf("filter = %s, want the enforced filter AND the query's own", got)
+	}
+
+	for _, query := range []model.VariableQuery{
+		{Type: model.VariableQueryDimensionValues, WebPropertyID: "properties/20", Dimension: "city"},
+		{Type: model.VariableQueryDataStreams, WebPropertyID: "properties/20"},
+	} {
+		if _, err := ga.GetVariableValues(context.Background(), config, query); !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("%s of properties/20: err = %v, want ErrNotAllowed", query.Type, err)
+		}
+	}
+	if paths, _ := fake.requests(); len(paths) != 1 {
+		t.Errorf("disallowed properties must not reach GA, got %v", paths)
+	}
+}
+
+func TestGetDimensionValueSuggestions(t *testing.T) {
+	prefixFilter := func(matchType string) *analyticsdata.FilterExpression {
+		return &analyticsdata.FilterExpression{
+			Filter: &analyticsdata.Filter{FieldName: "pagePath", StringFilter: &analyticsdata.StringFilter{MatchType: matchType, Value: "/blog"}},
+		}
+	}
+	tests := []struct {
+		name       string
+		prefix     string
+		matchType  string
+		days       int64
+		limit      int64
+		wantStart  string
+		wantLimit  int64
+		wantFilter *analyticsdata.FilterExpression
+	}{
+		{name: "defaults", wantStart: "30daysAgo", wantLimit: GaSuggestionDefaultLimit},
+		{name: "begins with by default", prefix: "/blog", wantStart: "30daysAgo", wantLimit: GaSuggestionDefaultLimit, wantFilter: prefixFilter("BEGINS_WITH")},
+		{name: "begins with", prefix: "/blog", matchType: "BEGINS_WITH", days: 7, limit: 5, wantStart: "7daysAgo", wantLimit: 5, wantFilter: prefixFilter("BEGINS_WITH")},
+		{name: "contains", prefix: "/blog", matchType: "CONTAINS", days: 90, wantStart: "90daysAgo", wantLimit: GaSuggestionDefaultLimit, wantFilter: prefixFilter("CONTAINS")},
+		{name: "limit above the maximum", limit: GaVariableMaxResult + 1, wantStart: "30daysAgo", wantLimit: GaVariableMaxResult},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGA{}
+			ga := newFakeGoogleAnalytics(t, fake)
+			got, err := ga.GetDimensionValueSuggestions(context.Background(), variableConfig(), "properties/10", "pagePath", tt.prefix, tt.matchType, tt.days, tt.limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, []string{"US", "KR"}) {
+				t.Errorf("suggestions = %v, want GA's order", got)
+			}
+			_, reports := fake.requests()
+			want := analyticsdata.RunReportRequest{
+				DateRanges:      []*analyticsdata.DateRange{{StartDate: tt.wantStart, EndDate: "today"}},
+				Dimensions:      []*analyticsdata.Dimension{{Name: "pagePath"}},
+				Metrics:         []*analyticsdata.Metric{{Name: "eventCount"}},
+				OrderBys:        []*analyticsdata.OrderBy{{Metric: &analyticsdata.MetricOrderBy{MetricName: "eventCount"}, Desc: true}},
+				Limit:           tt.wantLimit,
+				DimensionFilter: tt.wantFilter,
+			}
+			if len(reports) != 1 || !reflect.DeepEqual(reports[0], want) {
+				got, _ := json.Marshal(reports)
+				want, _ := json.Marshal(want)
+				t.Errorf("requests = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestGetDimensionValueSuggestions_Invalid(t *testing.T) {
+	tests := []struct {
+		name, property, dimension, matchType, wantErr string
+	}{
+		{"no property", "", "pagePath", "", "required webpropertyid"},
+		{"no dimension", "properties/10", "", "", "required dimension"},
+		{"exact match", "properties/10", "pagePath", "EXACT", `unsupported match type "EXACT"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGA{}
+			ga := newFakeGoogleAnalytics(t, fake)
+			_, err := ga.GetDimensionValueSuggestions(context.Background(), variableConfig(), tt.property, tt.dimension, "/blog", tt.matchType, 0, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+			if paths, _ := fake.requests(); len(paths) != 0 {
+				t.Errorf("invalid requests must not reach GA, got %v", paths)
+			}
+		})
+	}
+}
+
+func TestGetDimensionValueSuggestions_Cache(t *testing.T) {
+	fake := &fakeGA{}
+	ga := newFakeGoogleAnalytics(t, fake)
+	config := variableConfig()
+	fingerprint, err := credentialFingerprint(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suggest := func(prefix, matchType string) {
+		t.Helper()
+		if _, err := ga.GetDimensionValueSuggestions(context.Background(), config, "properties/10", "pagePath", prefix, matchType, 0, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	suggest("/blog", "")
+	suggest("/blog", "BEGINS_WITH")
+	if paths, _ := fake.requests(); len(paths) != 1 {
+		t.Errorf("the defaulted match type must share the cache entry, GA was called for %v", paths)
+	}
+	key := newCacheKey(CacheNamespaceVariables, fingerprint, "properties/10", "dimension", "pagePath", "suggestions", "BEGINS_WITH:/blog:30:20")
+	if _, found := ga.Cache.Get(key); !found {
+		t.Errorf("no cache entry %q", key)
+	}
+	suggest("/blog", "CONTAINS")
+	suggest("/blo", "")
+	if paths, _ := fake.requests(); len(paths) != 3 {
+		t.Errorf("other match types and prefixes must not share the cache entry, GA was called for %v", paths)
+	}
+}
@@ -90,6 +90,15 @@ type MetadataItemAttribute struct {
 	AllowedInSegments string        `json:"allowedInSegments,omitempty"`
 	AddedInAPIVersion string        `json:"addedInApiVersion,omitempty"`
 	ReplacedBy        string        `json:"replacedBy,omitempty"`
+	// CustomDefinition is set for custom dimensions, custom metrics and
+	// calculated metrics of the property
+	CustomDefinition   bool     `json:"customDefinition,omitempty"`
+	DeprecatedAPINames []string `json:"deprecatedApiNames,omitempty"`
+	// Expression is the formula of a calculated metric
+	Expression string `json:"expression,omitempty"`
+	// Scope and ParameterName come from the Admin API custom definition
+	Scope         string `json:"scope,omitempty"`
+	ParameterName string `json:"parameterName,omitempty"`
 }
 
 type AttributeType string
@@ -108,17 +117,108 @@ type ProfileSummary struct {
 	Profile, DisplayName, Parent, Type string
 }
 
+// VariableQueryType selects what a template variable query lists
+type VariableQueryType string
+
+const (
+	VariableQueryAccounts        VariableQueryType = "accounts"
+	VariableQueryProperties      VariableQueryType = "properties"
+	VariableQueryDataStreams     VariableQueryType = "dataStreams"
+	VariableQueryDimensionValues VariableQueryType = "dimensionValues"
+)
+
+type VariableQuery struct {
+	Type          VariableQueryType               `json:"type"`
+	AccountID     string                          `json:"accountId"`
+	WebPropertyID string                          `json:"webPropertyId"`
+	Dimension     string                          `json:"dimension"`
+	StartDate     string                          `json:"startDate"`
+	EndDate       string                          `json:"endDate"`
+	Filter        *analyticsdata.FilterExpression `json:"filter,omitempty"`
+	Limit         int64                           `json:"limit,omitempty"`
+}
+
+// MetricFindValue is a text/value pair of a template variable
+type MetricFindValue struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// FieldCompatibility tells whether a dimension or metric can join a query
+type FieldCompatibility struct {
+	ID            string `json:"id"`
+	UIName        string `json:"uiName"`
+	Compatibility string `json:"compatibility"`
+}
+
+type Compatibility struct {
+	Dimensions []FieldCompatibility `json:"dimensions"`
+	Metrics    []FieldCompatibility `json:"metrics"`
+}
+
+// CacheNamespaceStats describes the cached entries of one namespace
+type CacheNamespaceStats struct {
+	Entries  int64   `json:"entries"`
+	Bytes    int64   `json:"bytes"`
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+}
+
+type CacheStats struct {
+	Namespaces map[string]*CacheNamespaceStats `json:"namespaces"`
+	Total      CacheNamespaceStats             `json:"total"`
+}
+
 type QueryMode string
 
 const (
 	TIME_SERIES QueryMode = "time series"
 	TABLE       QueryMode = "table"
 	REALTIME    QueryMode = "realtime"
+	ANNOTATIONS QueryMode = "annotations"
+)
+
+// AnnotationType selects where an annotations query reads its events from
+type AnnotationType string
+
+const (
+	AnnotationChangeHistory AnnotationType = "changeHistory"
+	AnnotationEvents        AnnotationType = "events"
+)
+
+// CalculatedField is a number column computed per row from an arithmetic
+// expression over metric names, e.g. round(purchaseRevenue / activeUsers, 2)
+type CalculatedField struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+}
+
+// EditorMode tells how the query was written
+type EditorMode string
+
+const (
+	EditorModeBuilder EditorMode = ""
+	// EditorModeRaw queries carry a single text statement in RawQuery
+	EditorModeRaw EditorMode = "raw"
+)
+
+// PropertyMerge decides how a query over several properties is returned
+type PropertyMerge string
+
+const (
+	// PropertyMergeNone returns the frames of every property, labelled with its name
+	PropertyMergeNone PropertyMerge = ""
+	// PropertyMergeSum adds up the metrics of rows with the same dimension values
+	PropertyMergeSum PropertyMerge = "sum"
 )
 
 type QueryModel struct {
 	AccountID         string       `json:"accountId"`
 	WebPropertyID     string       `json:"webPropertyId"`
+	// Additional properties the report runs against, see PropertyMerge
+	WebPropertyIDs []string      `json:"webPropertyIds,omitempty"`
+	PropertyMerge  PropertyMerge `json:"propertyMerge,omitempty"`
 	ProfileID         string       `json:"profileId"`
 	StartDate         string       `json:"startDate"`
 	EndDate           string       `json:"endDate"`
@@ -136,6 +236,32 @@ type QueryModel struct {
 	ServiceLevel      ServiceLevel `json:"serviceLevel,omitempty"`
 	DimensionFilter *analyticsdata.FilterExpression `json:"dimensionFilter,omitempty"`
 	MetricFilter    *analyticsdata.FilterExpression `json:"metricFilter,omitempty"`
+	// Streaming realtime queries are pushed over Grafana Live every StreamInterval seconds
+	Streaming      bool  `json:"streaming,omitempty"`
+	StreamInterval int64 `json:"streamInterval,omitempty"`
+	// Named minute ranges of a realtime query, the time picker range is used when empty
+	MinuteRanges []*analyticsdata.MinuteRange `json:"minuteRanges,omitempty"`
+	AnnotationType AnnotationType `json:"annotationType,omitempty"`
+	// Event names turned into annotations by an events annotation query
+	EventNames []string `json:"eventNames,omitempty"`
+	// How long the report response is cached, the datasource default when unset
+	// and no caching when 0
+	CacheDurationSeconds *int64 `json:"cacheDurationSeconds,omitempty"`
+	// Raw queries are parsed into the fields above, see EditorModeRaw
+	EditorMode EditorMode `json:"editorMode,omitempty"`
+	RawQuery   string     `json:"rawQuery,omitempty"`
+	// Row order and row limit of the report, the first dimension and every
+	// row when unset
+	OrderBys []*analyticsdata.OrderBy `json:"orderBys,omitempty"`
+	Limit    int64                    `json:"limit,omitempty"`
+	// Number columns computed from the metrics of every row
+	CalculatedFields []CalculatedField `json:"calculatedFields,omitempty"`
+	// Current template variable values, applied by the backend
+	Variables map[string][]string `json:"variables,omitempty"`
+	// Variable values saved with the query by the editor, used for variables
+	// missing from Variables when no browser runs the query (alerting, public
+	// dashboards, reporting)
+	VariableDefaults map[string][]string `json:"variableDefaults,omitempty"`
 
 	From time.Time
 	To   time.Time
@@ -3,8 +3,10 @@ package setting
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	analyticsdata "google.golang.org/api/analyticsdata/v1beta"
 )
 
 // DatasourceSecretSettings contains Google Analytics datasource auth properties.
@@ -17,6 +19,23 @@ type DatasourceSecretSettings struct {
 	TokenURI           string `json:"tokenUri"`
 	DefaultProject     string `json:"defaultProject"`
 
+	// Report cache duration for queries that do not set cacheDurationSeconds
+	DefaultCacheDurationSeconds int64 `json:"defaultCacheDurationSeconds,omitempty"`
+	// Keep the cache in a file so that it survives plugin restarts
+	PersistentCache          bool   `json:"persistentCache,omitempty"`
+	PersistentCacheDirectory string `json:"persistentCacheDirectory,omitempty"`
+	PersistentCacheMaxSizeMB int64  `json:"persistentCacheMaxSizeMb,omitempty"`
+
+	// Restrictions set by the Grafana admin. Empty allowlists expose everything
+	// the credentials can see; the enforced filter is ANDed into every report.
+	AllowedAccounts         []string                        `json:"allowedAccounts,omitempty"`
+	AllowedProperties       []string                        `json:"allowedProperties,omitempty"`
+	EnforcedDimensionFilter *analyticsdata.FilterExpression `json:"enforcedDimensionFilter,omitempty"`
+
+	// Property the health check runs a test report against, instead of the
+	// first property the credentials can see
+	HealthCheckPropertyID string `json:"healthCheckPropertyId,omitempty"`
+
 	// secureJsonData
 	JWT        string `json:"jwt"`        // legacy: full service-account JSON blob
 	PrivateKey string `json:"privateKey"` // new: just the PEM private key
@@ -36,5 +55,33 @@ func LoadSettings(ctx backend.PluginContext) (*DatasourceSecretSettings, error)
 	model.JWT = settings.DecryptedSecureJSONData["jwt"]
 	model.PrivateKey = settings.DecryptedSecureJSONData["privateKey"]
 
+	model.AllowedAccounts = normalizeResourceNames(model.AllowedAccounts, "accounts/")
+	model.AllowedProperties = normalizeResourceNames(model.AllowedProperties, "properties/")
+	if ids := normalizeResourceNames([]string{model.HealthCheckPropertyID}, "properties/"); len(ids) > 0 {
+		model.HealthCheckPropertyID = ids[0]
+	}
+
 	return model, nil
 }
+
+// Restricted reports whether the datasource limits the accounts and properties
+// it exposes.
+func (s *DatasourceSecretSettings) Restricted() bool {
+	return len(s.AllowedAccounts) > 0 || len(s.AllowedProperties) > 0
+}
+
+// normalizeResourceNames accepts both "123" and "properties/123" style IDs
+func normalizeResourceNames(ids []string, prefix string) []string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !strings.HasPrefix(id, prefix) {
+			id = prefix + id
+		}
+		names = append(names, id)
+	}
+	return names
+}
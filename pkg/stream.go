@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/gav4"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/model"
+	"github.com/blackcowmoo/grafana-google-analytics-dataSource/pkg/setting"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/patrickmn/go-cache"
+)
+
+// realtimeStream is a streaming realtime query registered by QueryData and
+// waiting for Grafana Live subscribers.
+type realtimeStream struct {
+	query      backend.DataQuery
+	registered time.Time
+}
+
+// registerRealtimeStream remembers a streaming realtime query and returns the
+// channel its frames should point the panel at.
+func (ds *GoogleAnalyticsDataSource) registerRealtimeStream(pluginContext backend.PluginContext, query backend.DataQuery) (string, bool) {
+	if pluginContext.DataSourceInstanceSettings == nil {
+		return "", false
+	}
+	queryModel, err := gav4.GetQueryModel(query)
+	if err != nil || queryModel.Mode != model.REALTIME || !queryModel.Streaming {
+		return "", false
+	}
+	path, err := gav4.RealtimeStreamPath(queryModel)
+	if err != nil {
+		log.DefaultLogger.Error("registerRealtimeStream: Fail RealtimeStreamPath", "error", err.Error())
+		return "", false
+	}
+	ds.streams.Set(path, realtimeStream{query: query, registered: time.Now()}, cache.DefaultExpiration)
+
+	channel := live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: pluginContext.DataSourceInstanceSettings.UID,
+		Path:      path,
+	}
+	return channel.String(), true
+}
+
+func setFramesChannel(frames data.Frames, channel string) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Channel = channel
+	}
+}
+
+// SubscribeStream accepts subscriptions to realtime channels registered by QueryData
+func (ds *GoogleAnalyticsDataSource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if _, found := ds.streams.Get(req.Path); !found {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// PublishStream rejects publishing, realtime channels are only written by the plugin
+func (ds *GoogleAnalyticsDataSource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
+
+// RunStream polls the realtime report of a channel until its last subscriber leaves.
+// Grafana runs one RunStream per channel, so all panels sharing a query share the poller.
+func (ds *GoogleAnalyticsDataSource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	item, found := ds.streams.Get(req.Path)
+	if !found {
+		return fmt.Errorf("unknown stream: %s", req.Path)
+	}
+	stream := item.(realtimeStream)
+
+	config, err := setting.LoadSettings(req.PluginContext)
+	if err != nil {
+		log.DefaultLogger.Error("RunStream: Fail LoadSetting", "error", err.Error())
+		return err
+	}
+
+	// The stored range was relative to when the query ran, move it up to now
+	query := stream.query
+	shift := time.Since(stream.registered)
+	query.TimeRange.From = query.TimeRange.From.Add(shift)
+	query.TimeRange.To = query.TimeRange.To.Add(shift)
+
+	return ds.analytics.RunRealtimeStream(ctx, config, query, sender)
+}
@@ -69,6 +69,15 @@ func FillArray(array []string, value string) []string {
 	return array
 }
 
+func Contains(array []string, value string) bool {
+	for _, v := range array {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
 func TypeConverter[R any](data any) (*R, error) {
 	var result R
 	b, err := json.Marshal(&data)
@@ -101,3 +101,12 @@ func TestTypeConverter(t *testing.T) {
 		t.Errorf("converted = %+v, want {A:7 B:ok}", got)
 	}
 }
+
+func TestContains(t *testing.T) {
+	if !Contains([]string{"a", "b"}, "b") {
+		t.Error("expected b to be found")
+	}
+	if Contains([]string{"a", "b"}, "c") || Contains(nil, "a") {
+		t.Error("unexpected match")
+	}
+}
@@ -1,13 +1,36 @@
 import { DataSourcePluginOptionsEditorProps } from '@grafana/data';
 import { ConnectionConfig } from '@grafana/google-sdk';
-import { Alert } from '@grafana/ui';
-import React from 'react';
+import { Alert, InlineField, InlineSwitch, Input, TagsInput, TextArea } from '@grafana/ui';
+import React, { useState } from 'react';
 import { GADataSourceOptions, GASecureJsonData } from 'types';
 
 export type Props = DataSourcePluginOptionsEditorProps<GADataSourceOptions, GASecureJsonData>;
 
 export const ConfigEditor: React.FC<Props> = (props) => {
-  const { options } = props;
+  const { options, onOptionsChange } = props;
+  const { jsonData } = options;
+  const [filterText, setFilterText] = useState(
+    jsonData.enforcedDimensionFilter ? JSON.stringify(jsonData.enforcedDimensionFilter, null, 2) : ''
+  );
+  const [filterError, setFilterError] = useState('');
+
+  const onJsonDataChange = (change: Partial<GADataSourceOptions>) => {
+    onOptionsChange({ ...options, jsonData: { ...jsonData, ...change } });
+  };
+
+  const onFilterBlur = () => {
+    if (filterText.trim() === '') {
+      setFilterError('');
+      onJsonDataChange({ enforcedDimensionFilter: undefined });
+      return;
+    }
+    try {
+      onJsonDataChange({ enforcedDimensionFilter: JSON.parse(filterText) });
+      setFilterError('');
+    } catch (e) {
+      setFilterError(`Invalid filter JSON: ${e instanceof Error ? e.message : e}`);
+    }
+  };
 
   // Backwards compat: a datasource created before @grafana/google-sdk
   // adoption stores the full service-account JSON in `secureJsonData.jwt`.
@@ -33,6 +56,114 @@ export const ConfigEditor: React.FC<Props> = (props) => {
 
       <ConnectionConfig {...props} />
 
+      <h3 className="page-heading">Caching</h3>
+      <InlineField
+        label="Default cache duration"
+        labelWidth={24}
+        tooltip="Seconds a report is served from the cache when the query does not set its own duration. Defaults to 300."
+      >
+        <Input
+          type="number"
+          width={20}
+          min={0}
+          placeholder="300"
+          value={jsonData.defaultCacheDurationSeconds ?? ''}
+          onChange={(e) => {
+            const value = parseInt(e.currentTarget.value, 10);
+            onJsonDataChange({ defaultCacheDurationSeconds: isNaN(value) ? undefined : value });
+          }}
+        />
+      </InlineField>
+
+      <InlineField
+        label="Persistent cache"
+        labelWidth={24}
+        tooltip="Keep cached reports and metadata in a file so that they survive Grafana and plugin restarts."
+      >
+        <InlineSwitch
+          value={jsonData.persistentCache ?? false}
+          onChange={(e) => onJsonDataChange({ persistentCache: e.currentTarget.checked })}
+        />
+      </InlineField>
+      {jsonData.persistentCache && (
+        <>
+          <InlineField
+            label="Cache directory"
+            labelWidth={24}
+            tooltip="Directory of the cache file. Defaults to the user cache directory of the Grafana server."
+          >
+            <Input
+              width={40}
+              value={jsonData.persistentCacheDirectory ?? ''}
+              onChange={(e) => onJsonDataChange({ persistentCacheDirectory: e.currentTarget.value || undefined })}
+            />
+          </InlineField>
+          <InlineField label="Max cache size (MB)" labelWidth={24} tooltip="Defaults to 100 MB.">
+            <Input
+              type="number"
+              width={20}
+              min={1}
+              placeholder="100"
+              value={jsonData.persistentCacheMaxSizeMb ?? ''}
+              onChange={(e) => {
+                const value = parseInt(e.currentTarget.value, 10);
+                onJsonDataChange({ persistentCacheMaxSizeMb: isNaN(value) ? undefined : value });
+              }}
+            />
+          </InlineField>
+        </>
+      )}
+
+      <h3 className="page-heading">Health check</h3>
+      <InlineField
+        label="Test property"
+        labelWidth={24}
+        tooltip="Property the health check runs a test report against. Defaults to the first property the credentials can see."
+      >
+        <Input
+          width={40}
+          placeholder="properties/456"
+          value={jsonData.healthCheckPropertyId ?? ''}
+          onChange={(e) => onJsonDataChange({ healthCheckPropertyId: e.currentTarget.value || undefined })}
+        />
+      </InlineField>
+
+      <h3 className="page-heading">Restrictions</h3>
+      <InlineField
+        label="Allowed accounts"
+        labelWidth={24}
+        tooltip="Account IDs this datasource exposes, with all of their properties. Leave both lists empty to expose everything the credentials can see."
+      >
+        <TagsInput
+          tags={jsonData.allowedAccounts ?? []}
+          placeholder="accounts/123"
+          onChange={(allowedAccounts) => onJsonDataChange({ allowedAccounts })}
+        />
+      </InlineField>
+      <InlineField label="Allowed properties" labelWidth={24} tooltip="Property IDs this datasource exposes.">
+        <TagsInput
+          tags={jsonData.allowedProperties ?? []}
+          placeholder="properties/456"
+          onChange={(allowedProperties) => onJsonDataChange({ allowedProperties })}
+        />
+      </InlineField>
+      <InlineField
+        label="Enforced dimension filter"
+        labelWidth={24}
+        tooltip="A GA4 FilterExpression that is ANDed into every report, e.g. a hostName filter."
+        invalid={filterError !== ''}
+        error={filterError}
+      >
+        <TextArea
+          rows={6}
+          cols={60}
+          value={filterText}
+          placeholder='{"filter": {"fieldName": "hostName", "stringFilter": {"matchType": "EXACT", "value": "shop.example.com"}}}'
+          onChange={(e) => setFilterText(e.currentTarget.value)}
+          onBlur={onFilterBlur}
+        />
+      </InlineField>
+
       <Alert title="Generate a JWT file" severity="info">
         <ol style={{ listStylePosition: 'inside' }}>
           <li>
@@ -93,7 +224,8 @@ export const ConfigEditor: React.FC<Props> = (props) => {
           <li>Click plus Button then Add users</li>
           <li>
             Enter <code>service account email</code> at <strong>Generate a JWT file</strong> 8th step and Permissions
-            add <code>Read &amp; Analyze</code>
+            add <code>Read &amp; Analyze</code> (<code>Edit</code> for change history annotations, which need the{' '}
+            <code>analytics.edit</code> scope)
           </li>
         </ol>
       </Alert>
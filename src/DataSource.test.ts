@@ -113,6 +113,17 @@ describe('DataSource.applyTemplateVariables', () => {
     expect(interpolated.webPropertyId).toBe('properties/987');
   });
 
+  it('spreads a multi-value property variable over webPropertyIds', () => {
+    setVars({ site: ['properties/1', 'properties/2'] });
+    const ds = makeDataSource();
+    const query = makeQuery({ webPropertyId: '$site', webPropertyIds: ['properties/3'] });
+
+    const interpolated = ds.applyTemplateVariables(query, {});
+
+    expect(interpolated.webPropertyId).toBe('properties/1');
+    expect(interpolated.webPropertyIds).toEqual(['properties/2', 'properties/3']);
+  });
+
   it('passes dimensionFilter through unchanged when no variables are referenced', () => {
     setVars({});
     const ds = makeDataSource();
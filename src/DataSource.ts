@@ -1,12 +1,14 @@
 import { DataSourceInstanceSettings, MetricFindValue, ScopedVars, SelectableValue } from '@grafana/data';
 import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
 import { CascaderOption } from '@grafana/ui';
-import { interpolateFilterExpression } from './interpolation';
-import { AccountSummary, GADataSourceOptions, GAFilterExpression, GAMetadata, GAQuery } from './types';
+import { expandVariableToArray, interpolateFilterExpression, variableValues } from './interpolation';
+import { AccountSummary, GACompatibility, GADataSourceOptions, GAFilterExpression, GAMetadata, GAQuery } from './types';
 
 export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<GADataSourceOptions>) {
     super(instanceSettings);
+    // Annotation queries (mode "annotations") are answered by the backend
+    this.annotations = {};
   }
 
   applyTemplateVariables(query: GAQuery, scopedVars: ScopedVars): Record<string, any> {
@@ -23,14 +25,25 @@ export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptio
       : undefined;
     interpolateFilterExpression(templateSrv, metricFilter, scopedVars);
 
-    // Apply template variable interpolation to webPropertyId
-    const webPropertyId = templateSrv.replace(query.webPropertyId, scopedVars);
+    // Apply template variable interpolation to webPropertyId. A multi-value
+    // variable runs the query against every selected property.
+    const [webPropertyId, ...otherPropertyIds] = expandVariableToArray(templateSrv, query.webPropertyId, scopedVars);
+    const webPropertyIds = [...otherPropertyIds];
+    for (const id of query.webPropertyIds ?? []) {
+      webPropertyIds.push(...expandVariableToArray(templateSrv, id, scopedVars));
+    }
+
+    // Send the current variable values along, the backend interpolates the
+    // remaining fields (metrics, dimensions) with them.
+    const variables = variableValues(templateSrv, scopedVars);
 
     return {
       ...query,
       webPropertyId,
+      webPropertyIds,
       dimensionFilter,
       metricFilter,
+      variables,
     };
   }
   async getAccountSummaries(): Promise<CascaderOption[]> {
@@ -92,7 +105,7 @@ export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptio
           pre.push({
             label: element.attributes.uiName,
             value: element.id,
-            description: element.attributes.description,
+            description: metadataDescription(element),
           } as SelectableValue<string>);
         }
         return pre;
@@ -115,7 +128,7 @@ export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptio
           pre.push({
             label: element.attributes.uiName,
             value: element.id,
-            description: element.attributes.description,
+            description: metadataDescription(element),
           } as SelectableValue<string>);
         }
         return pre;
@@ -168,41 +181,73 @@ export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptio
     });
   }
 
+  async getDimensionValues(webPropertyId: string, dimension: string, prefix: string): Promise<string[]> {
+    webPropertyId = getTemplateSrv().replace(webPropertyId);
+    return this.getResource('dimension-values', { webPropertyId, dimension, prefix }).then(({ values }) => {
+      return values ?? [];
+    });
+  }
+
+  async getCompatibility(
+    webPropertyId: string,
+    metrics: string[],
+    dimensions: string[],
+    dimensionFilter?: GAFilterExpression,
+    metricFilter?: GAFilterExpression
+  ): Promise<GACompatibility> {
+    return this.getResource('compatibility', {
+      webPropertyId,
+      metrics: metrics.join(','),
+      dimensions: dimensions.join(','),
+      dimensionFilter: dimensionFilter ? JSON.stringify(dimensionFilter) : '',
+      metricFilter: metricFilter ? JSON.stringify(metricFilter) : '',
+    }).then(({ compatibility }) => {
+      return compatibility;
+    });
+  }
+
+  /**
+   * Supported variable queries:
+   *   accounts
+   *   properties | properties(<accountId>)
+   *   dataStreams(<propertyId>)
+   *   dimensionValues(<propertyId>, <dimension>[, <startDate>, <endDate>])
+   */
   async metricFindQuery(query: string, options?: { scopedVars?: ScopedVars }): Promise<MetricFindValue[]> {
     const templateSrv = getTemplateSrv();
     const interpolated = templateSrv.replace(query.trim(), options?.scopedVars);
 
-    const accountSummaries = (await this.getResource('account-summaries')).accountSummaries as AccountSummary[];
-
-    const propertiesMatch = interpolated.match(/^properties\(([^)]+)\)$/i);
-    if (propertiesMatch) {
-      const accountId = propertiesMatch[1].trim();
-      const account = accountSummaries.find(
-        (a) => a.Account === accountId || a.Account === `accounts/${accountId}`
-      );
-      return (account?.PropertySummaries ?? []).map((p) => ({
-        text: p.DisplayName,
-        value: p.Property,
-      }));
-    }
-
-    if (/^properties$/i.test(interpolated)) {
-      return accountSummaries.flatMap((a) =>
-        (a.PropertySummaries ?? []).map((p) => ({
-          text: p.DisplayName,
-          value: p.Property,
-        }))
-      );
+    const match = interpolated.match(/^(\w+)(?:\(([^)]*)\))?$/);
+    if (!match) {
+      return [];
     }
-
-    if (/^accounts$/i.test(interpolated)) {
-      return accountSummaries.map((a) => ({
-        text: a.DisplayName,
-        value: a.Account,
-      }));
+    const args = (match[2] ?? '').split(',').map((arg) => arg.trim());
+    let params: Record<string, string>;
+    switch (match[1].toLowerCase()) {
+      case 'accounts':
+        params = { type: 'accounts' };
+        break;
+      case 'properties':
+        params = { type: 'properties', accountId: args[0] };
+        break;
+      case 'datastreams':
+        params = { type: 'dataStreams', webPropertyId: args[0] };
+        break;
+      case 'dimensionvalues':
+        params = {
+          type: 'dimensionValues',
+          webPropertyId: args[0],
+          dimension: args[1] ?? '',
+          startDate: args[2] ?? '',
+          endDate: args[3] ?? '',
+        };
+        break;
+      default:
+        return [];
     }
 
-    return [];
+    const { values } = await this.getResource('variable-query', params);
+    return values ?? [];
   }
 
   async getTimeDimensions(): Promise<Array<SelectableValue<string>>> {
@@ -216,3 +261,16 @@ export class DataSource extends DataSourceWithBackend<GAQuery, GADataSourceOptio
     return await this.getDimensions(query, 'date', webPropertyId);
   }
 }
+
+// metadataDescription shows how custom fields are derived next to their description
+export function metadataDescription(element: GAMetadata): string {
+  const { description, customDefinition, expression, scope, parameterName } = element.attributes;
+  const details: string[] = [];
+  if (customDefinition && scope && parameterName) {
+    details.push(`${scope.toLowerCase()} parameter ${parameterName}`);
+  }
+  if (expression && expression !== element.id) {
+    details.push(`= ${expression}`);
+  }
+  return details.length > 0 ? `${description} (${details.join(', ')})` : description;
+}
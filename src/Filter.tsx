@@ -25,12 +25,16 @@ import {
 } from 'types';
 
 export type LoadFieldsFn = (query: string) => Promise<Array<SelectableValue<string>>>;
+/** Suggests values of a field starting with prefix */
+export type LoadValuesFn = (fieldName: string, prefix: string) => Promise<Array<SelectableValue<string>>>;
 
 export interface GAFilterExpressionComponentProps {
   expression: GAFilterExpression;
   onChange: (expr: GAFilterExpression) => void;
   onDelete?: () => void;
   loadFields: LoadFieldsFn;
+  /** Value suggestions for string and in-list filters, free text when unset */
+  loadValues?: LoadValuesFn;
   /** Visual nesting depth — drives indentation and border colour */
   depth?: number;
 }
@@ -175,9 +179,10 @@ const getStyles = (theme: GrafanaTheme2) => {
 interface StringEditorProps {
   filter: GAStringFilter;
   onChange: (f: GAStringFilter) => void;
+  loadValues?: (prefix: string) => Promise<Array<SelectableValue<string>>>;
   styles: ReturnType<typeof getStyles>;
 }
-const StringEditor: React.FC<StringEditorProps> = ({ filter, onChange, styles }) => (
+const StringEditor: React.FC<StringEditorProps> = ({ filter, onChange, loadValues, styles }) => (
   <>
     <Select<GAStringFilterMatchType>
       options={STRING_MATCH_OPTIONS}
@@ -186,12 +191,26 @@ const StringEditor: React.FC<StringEditorProps> = ({ filter, onChange, styles })
       width={16}
       menuPlacement="bottom"
     />
-    <Input
-      value={filter.value}
-      onChange={(e) => onChange({ ...filter, value: e.currentTarget.value })}
-      placeholder="value or $variable"
-      width={20}
-    />
+    {loadValues ? (
+      <AsyncSelect
+        loadOptions={loadValues}
+        value={filter.value ? { label: filter.value, value: filter.value } : null}
+        onChange={(o) => onChange({ ...filter, value: o?.value ?? '' })}
+        placeholder="value or $variable"
+        allowCustomValue
+        width={20}
+        defaultOptions
+        menuPlacement="bottom"
+        isClearable
+      />
+    ) : (
+      <Input
+        value={filter.value}
+        onChange={(e) => onChange({ ...filter, value: e.currentTarget.value })}
+        placeholder="value or $variable"
+        width={20}
+      />
+    )}
     <span className={styles.caseLabel}>Aa</span>
     <InlineSwitch
       value={filter.caseSensitive}
@@ -205,9 +224,10 @@ const StringEditor: React.FC<StringEditorProps> = ({ filter, onChange, styles })
 interface InListEditorProps {
   filter: GAInListFilter;
   onChange: (f: GAInListFilter) => void;
+  loadValues?: (prefix: string) => Promise<Array<SelectableValue<string>>>;
   styles: ReturnType<typeof getStyles>;
 }
-const InListEditor: React.FC<InListEditorProps> = ({ filter, onChange, styles }) => (
+const InListEditor: React.FC<InListEditorProps> = ({ filter, onChange, loadValues, styles }) => (
   <>
     <div className={styles.tagInput}>
       <TagsInput
@@ -217,6 +237,21 @@ const InListEditor: React.FC<InListEditorProps> = ({ filter, onChange, styles })
         addOnBlur
       />
     </div>
+    {loadValues && (
+      <AsyncSelect
+        loadOptions={loadValues}
+        value={null}
+        onChange={(o) => {
+          if (o?.value && !filter.values.includes(o.value)) {
+            onChange({ ...filter, values: [...filter.values, o.value] });
+          }
+        }}
+        placeholder="suggested values"
+        width={20}
+        defaultOptions
+        menuPlacement="bottom"
+      />
+    )}
     <span className={styles.caseLabel}>Aa</span>
     <InlineSwitch
       value={filter.caseSensitive}
@@ -281,10 +316,16 @@ interface LeafFilterProps {
   filter: GAFilter;
   onChange: (f: GAFilter) => void;
   loadFields: LoadFieldsFn;
+  loadValues?: LoadValuesFn;
   styles: ReturnType<typeof getStyles>;
 }
 
-const LeafFilterEditor: React.FC<LeafFilterProps> = ({ filter, onChange, loadFields, styles }) => {
+const LeafFilterEditor: React.FC<LeafFilterProps> = ({ filter, onChange, loadFields, loadValues, styles }) => {
+  // Suggestions need the field; the key on the editors reloads them when it changes
+  const loadFieldValues = loadValues && filter.fieldName
+    ? (prefix: string) => loadValues(filter.fieldName, prefix)
+    : undefined;
+
   const handleTypeChange = (type: GADimensionFilterType) => {
     const base: GAFilter = { fieldName: filter.fieldName, filterType: type };
     switch (type) {
@@ -306,16 +347,20 @@ const LeafFilterEditor: React.FC<LeafFilterProps> = ({ filter, onChange, loadFie
       case GADimensionFilterType.STRING:
         return (
           <StringEditor
+            key={filter.fieldName}
             filter={filter.stringFilter ?? { matchType: GAStringFilterMatchType.EXACT, value: '', caseSensitive: false }}
             onChange={(f) => onChange({ ...filter, stringFilter: f })}
+            loadValues={loadFieldValues}
             styles={styles}
           />
         );
       case GADimensionFilterType.IN_LIST:
         return (
           <InListEditor
+            key={filter.fieldName}
             filter={filter.inListFilter ?? { values: [], caseSensitive: false }}
             onChange={(f) => onChange({ ...filter, inListFilter: f })}
+            loadValues={loadFieldValues}
             styles={styles}
           />
         );
@@ -373,6 +418,7 @@ export const GAFilterExpressionComponent: React.FC<GAFilterExpressionComponentPr
   onChange,
   onDelete,
   loadFields,
+  loadValues,
   depth = 0,
 }) => {
   const styles = useStyles2(getStyles);
@@ -425,6 +471,7 @@ export const GAFilterExpressionComponent: React.FC<GAFilterExpressionComponentPr
           filter={filter}
           onChange={(f) => onChange({ filter: f })}
           loadFields={loadFields}
+          loadValues={loadValues}
           styles={styles}
         />
         {onDelete && (
@@ -471,6 +518,7 @@ export const GAFilterExpressionComponent: React.FC<GAFilterExpressionComponentPr
               onChange={(c) => updateChild(i, c)}
               onDelete={() => deleteChild(i)}
               loadFields={loadFields}
+              loadValues={loadValues}
               depth={depth + 1}
             />
           ))}
@@ -494,6 +542,7 @@ export const GAFilterExpressionComponent: React.FC<GAFilterExpressionComponentPr
             expression={expression.notExpression!}
             onChange={(child) => onChange({ notExpression: child })}
             loadFields={loadFields}
+            loadValues={loadValues}
             depth={depth + 1}
           />
         </div>
@@ -1,5 +1,7 @@
 import { QueryEditorProps } from '@grafana/data';
+import { getTemplateSrv } from '@grafana/runtime';
 import { DataSource } from 'DataSource';
+import { variableValues } from 'interpolation';
 import { QueryEditorGA4 } from 'QueryEditorGA4';
 import React, { PureComponent } from 'react';
 import { GADataSourceOptions, GAQuery } from 'types';
@@ -7,8 +9,14 @@ import { GADataSourceOptions, GAQuery } from 'types';
 type Props = QueryEditorProps<DataSource, GAQuery, GADataSourceOptions>;
 
 export class QueryEditorCommon extends PureComponent<Props> {
+  // Save the variable values along with every edit, the backend falls back to
+  // them when the query runs without a dashboard
+  onChange = (query: GAQuery) => {
+    this.props.onChange({ ...query, variableDefaults: variableValues(getTemplateSrv(), {}) });
+  };
+
   render() {
-    const { query, datasource, onChange, onRunQuery } = this.props;
-    return <QueryEditorGA4 datasource={datasource} onChange={onChange} onRunQuery={onRunQuery} query={query}></QueryEditorGA4>;
+    const { query, datasource, onRunQuery } = this.props;
+    return <QueryEditorGA4 datasource={datasource} onChange={this.onChange} onRunQuery={onRunQuery} query={query}></QueryEditorGA4>;
   }
 }
@@ -9,13 +9,14 @@ import {
   InlineFormLabel,
   InlineLabel,
   RadioButtonGroup,
+  TextArea,
 } from '@grafana/ui';
 import { DataSource } from 'DataSource';
 import { GAFilterExpressionComponent } from 'Filter';
 import _ from 'lodash';
 import React, { PureComponent } from 'react';
 import { GADataSourceOptions, GAFilterExpression, GAQuery } from 'types';
-import type { LoadFieldsFn } from 'Filter';
+import type { LoadFieldsFn, LoadValuesFn } from 'Filter';
 type Props = QueryEditorProps<DataSource, GAQuery, GADataSourceOptions>;
 
 const defaultCacheDuration = 300;
@@ -25,6 +26,11 @@ const queryMode = [
   { label: 'Realtime', value: 'realtime' },
 ] as Array<SelectableValue<string>>;
 
+const editorModes = [
+  { label: 'Builder', value: '' },
+  { label: 'Raw', value: 'raw' },
+] as Array<SelectableValue<string>>;
+
 const gaServiceLevelBadge = {
   GOOGLE_ANALYTICS_STANDARD: {
     text: 'Standard',
@@ -139,10 +145,34 @@ export class QueryEditorGA4 extends PureComponent<Props> {
     this.willRunQuery();
   };
 
+  onEditorModeChange = (value: string) => {
+    const { query, onChange } = this.props;
+    onChange({ ...query, editorMode: value === 'raw' ? 'raw' : '' });
+    this.willRunQuery();
+  };
+
+  onRawQueryChange = (event: React.FocusEvent<HTMLTextAreaElement>) => {
+    const { query, onChange } = this.props;
+    onChange({ ...query, rawQuery: event.currentTarget.value });
+    this.willRunQuery();
+  };
+
+  loadDimensionValues: LoadValuesFn = async (dimension: string, prefix: string) => {
+    const { query, datasource } = this.props;
+    const values = await datasource.getDimensionValues(query.webPropertyId, dimension, prefix);
+    return values.map((value) => ({ label: value, value }));
+  };
+
   willRunQuery = _.debounce(() => {
     const { query, onRunQuery } = this.props;
-    const { webPropertyId, metrics, timeDimension, mode } = query;
+    const { webPropertyId, metrics, timeDimension, mode, editorMode, rawQuery } = query;
 
+    if (editorMode === 'raw') {
+      if (webPropertyId && rawQuery) {
+        onRunQuery();
+      }
+      return;
+    }
 
     if (webPropertyId && metrics && (mode === 'table' || mode === 'realtime' || timeDimension)) {
 
@@ -176,6 +206,8 @@ export class QueryEditorGA4 extends PureComponent<Props> {
       timezone,
       mode,
       serviceLevel,
+      editorMode,
+      rawQuery,
     } = query;
     const parsedWebPropertyId = webPropertyId?.split('/')[1];
     let serviceLevelBadge;
@@ -226,129 +258,161 @@ export class QueryEditorGA4 extends PureComponent<Props> {
             </HorizontalGroup>
           </div>
 
-          <div className="gf-form">
-            <InlineFormLabel
-              className="query-keyword"
-              tooltip={
-                <>
-                  The <code>metric</code> ga:*
-                </>
-              }
-            >
-              Metrics
-            </InlineFormLabel>
-            <AsyncMultiSelect
-              loadOptions={(q) => {
-
-                if(mode === "realtime"){
-                  return datasource.getRealtimeMetrics(q,parsedWebPropertyId)
-                }
-               return datasource.getMetrics(q, parsedWebPropertyId)}
-              }
-              placeholder={'ga:sessions'}
-              value={selectedMetrics}
-              onChange={this.onMetricChange}
-              backspaceRemovesValue
-              noOptionsMessage={'Search Metrics'}
-              defaultOptions
-              menuPlacement="bottom"
-              isClearable
-              key={mode+webPropertyId+"metrics"}
-              aria-label='metrics'
-            />
-
-            <InlineFormLabel
-              className="query-keyword"
-              tooltip={
-                <>
-                  The <code>time dimensions</code> At least one ga:date* is required.
-                </>
-              }
-            >
-              Time Dimension
-            </InlineFormLabel>
-            <AsyncSelect
-              loadOptions={() => datasource.getTimeDimensions()}
-              placeholder={'ga:dateHour'}
-              value={selectedTimeDimensions}
-              onChange={this.onTimeDimensionChange}
-              backspaceRemovesValue
-              noOptionsMessage={'Search Dimension'}
-              defaultOptions
-              menuPlacement="bottom"
-              isClearable
-              disabled={mode === 'realtime'}
-              aria-label='time-dimension'
-            />
-
-            <InlineFormLabel
-              className="query-keyword"
-              tooltip={
-                <>
-                  The <code>dimensions</code> exclude time dimensions
-                </>
-              }
-            >
-              Dimensions
-            </InlineFormLabel>
-            <AsyncMultiSelect
-              loadOptions={(q) => {
-                if(mode === "realtime"){
-                  return datasource.getRealtimeDimensions(q,null,parsedWebPropertyId)
+          {editorMode === 'raw' ? (
+            <div className="gf-form">
+              <InlineFormLabel
+                className="query-keyword"
+                tooltip={
+                  <>
+                    <code>SELECT metrics [BY dimensions] [WHERE ...] [ORDER BY ...] [LIMIT n]</code>
+                  </>
                 }
-                return datasource.getDimensionsExcludeTimeDimensions(q, parsedWebPropertyId);
-              }}
-              placeholder={'ga:country'}
-              value={selectedDimensions}
-              onChange={this.onDimensionChange}
-              backspaceRemovesValue
-              noOptionsMessage={'Search Dimension'}
-              defaultOptions
-              menuPlacement="bottom"
-              isClearable
-              key={mode+parsedWebPropertyId+"dimensions"}
-              aria-label='dimensions'
-            />
-          </div>
-          <div className="gf-form">
-            <InlineFormLabel
-              className="query-keyword"
-              tooltip="Filter rows by dimension values (applied before aggregation)"
-            >
-              Dimension Filter
-            </InlineFormLabel>
-            <GAFilterExpressionComponent
-              expression={query.dimensionFilter}
-              onChange={this.onFiltersExpressionChange}
-              loadFields={((q: string) => {
-                const loadDimFields: LoadFieldsFn = mode === 'realtime'
-                  ? (s) => datasource.getRealtimeDimensions(s, null, parsedWebPropertyId)
-                  : (s) => datasource.getDimensions(s, null, parsedWebPropertyId);
-                return loadDimFields(q);
-              }) as LoadFieldsFn}
-            />
-          </div>
-          <div className="gf-form">
-            <InlineFormLabel
-              className="query-keyword"
-              tooltip="Filter rows by metric values — applied after aggregation (SQL HAVING equivalent)"
-            >
-              Metric Filter
-            </InlineFormLabel>
-            <GAFilterExpressionComponent
-              expression={query.metricFilter ?? {}}
-              onChange={this.onMetricFilterChange}
-              loadFields={((q: string) => {
-                const loadMetFields: LoadFieldsFn = mode === 'realtime'
-                  ? (s) => datasource.getRealtimeMetrics(s, parsedWebPropertyId)
-                  : (s) => datasource.getMetrics(s, parsedWebPropertyId);
-                return loadMetFields(q);
-              }) as LoadFieldsFn}
-            />
-          </div>
+              >
+                Query
+              </InlineFormLabel>
+              <TextArea
+                defaultValue={rawQuery}
+                placeholder={"SELECT sessions, activeUsers BY date, country WHERE country IN ('US', 'CA') ORDER BY sessions DESC LIMIT 20"}
+                rows={4}
+                onBlur={this.onRawQueryChange}
+                aria-label='raw-query'
+              />
+            </div>
+          ) : (
+            <>
+              <div className="gf-form">
+                <InlineFormLabel
+                  className="query-keyword"
+                  tooltip={
+                    <>
+                      The <code>metric</code> ga:*
+                    </>
+                  }
+                >
+                  Metrics
+                </InlineFormLabel>
+                <AsyncMultiSelect
+                  loadOptions={(q) => {
+
+                    if(mode === "realtime"){
+                      return datasource.getRealtimeMetrics(q,parsedWebPropertyId)
+                    }
+                   return datasource.getMetrics(q, parsedWebPropertyId)}
+                  }
+                  placeholder={'ga:sessions'}
+                  value={selectedMetrics}
+                  onChange={this.onMetricChange}
+                  backspaceRemovesValue
+                  noOptionsMessage={'Search Metrics'}
+                  defaultOptions
+                  menuPlacement="bottom"
+                  isClearable
+                  key={mode+webPropertyId+"metrics"}
+                  aria-label='metrics'
+                />
+
+                <InlineFormLabel
+                  className="query-keyword"
+                  tooltip={
+                    <>
+                      The <code>time dimensions</code> At least one ga:date* is required.
+                    </>
+                  }
+                >
+                  Time Dimension
+                </InlineFormLabel>
+                <AsyncSelect
+                  loadOptions={() => datasource.getTimeDimensions()}
+                  placeholder={'ga:dateHour'}
+                  value={selectedTimeDimensions}
+                  onChange={this.onTimeDimensionChange}
+                  backspaceRemovesValue
+                  noOptionsMessage={'Search Dimension'}
+                  defaultOptions
+                  menuPlacement="bottom"
+                  isClearable
+                  disabled={mode === 'realtime'}
+                  aria-label='time-dimension'
+                />
+
+                <InlineFormLabel
+                  className="query-keyword"
+                  tooltip={
+                    <>
+                      The <code>dimensions</code> exclude time dimensions
+                    </>
+                  }
+                >
+                  Dimensions
+                </InlineFormLabel>
+                <AsyncMultiSelect
+                  loadOptions={(q) => {
+                    if(mode === "realtime"){
+                      return datasource.getRealtimeDimensions(q,null,parsedWebPropertyId)
+                    }
+                    return datasource.getDimensionsExcludeTimeDimensions(q, parsedWebPropertyId);
+                  }}
+                  placeholder={'ga:country'}
+                  value={selectedDimensions}
+                  onChange={this.onDimensionChange}
+                  backspaceRemovesValue
+                  noOptionsMessage={'Search Dimension'}
+                  defaultOptions
+                  menuPlacement="bottom"
+                  isClearable
+                  key={mode+parsedWebPropertyId+"dimensions"}
+                  aria-label='dimensions'
+                />
+              </div>
+              <div className="gf-form">
+                <InlineFormLabel
+                  className="query-keyword"
+                  tooltip="Filter rows by dimension values (applied before aggregation)"
+                >
+                  Dimension Filter
+                </InlineFormLabel>
+                <GAFilterExpressionComponent
+                  expression={query.dimensionFilter}
+                  onChange={this.onFiltersExpressionChange}
+                  loadFields={((q: string) => {
+                    const loadDimFields: LoadFieldsFn = mode === 'realtime'
+                      ? (s) => datasource.getRealtimeDimensions(s, null, parsedWebPropertyId)
+                      : (s) => datasource.getDimensions(s, null, parsedWebPropertyId);
+                    return loadDimFields(q);
+                  }) as LoadFieldsFn}
+                  loadValues={mode === 'realtime' || !webPropertyId ? undefined : this.loadDimensionValues}
+                />
+              </div>
+              <div className="gf-form">
+                <InlineFormLabel
+                  className="query-keyword"
+                  tooltip="Filter rows by metric values — applied after aggregation (SQL HAVING equivalent)"
+                >
+                  Metric Filter
+                </InlineFormLabel>
+                <GAFilterExpressionComponent
+                  expression={query.metricFilter ?? {}}
+                  onChange={this.onMetricFilterChange}
+                  loadFields={((q: string) => {
+                    const loadMetFields: LoadFieldsFn = mode === 'realtime'
+                      ? (s) => datasource.getRealtimeMetrics(s, parsedWebPropertyId)
+                      : (s) => datasource.getMetrics(s, parsedWebPropertyId);
+                    return loadMetFields(q);
+                  }) as LoadFieldsFn}
+                />
+              </div>
+            </>
+          )}
           <div className="gf-form">
             <InlineFormLabel className="query-keyword">Query Mode</InlineFormLabel>
             <RadioButtonGroup options={queryMode} onChange={this.onModeChange} value={mode} aria-label='query-mode' />
+            <InlineFormLabel className="query-keyword">Editor</InlineFormLabel>
+            <RadioButtonGroup
+              options={editorModes}
+              onChange={this.onEditorModeChange}
+              value={editorMode ?? ''}
+              aria-label='editor-mode'
+            />
           </div>
         </div>
       </>
@@ -25,6 +25,16 @@ export function expandVariableToArray(
   return multi ? out : [replaced];
 }
 
+// Current values of every dashboard variable, by name, for the backend to
+// interpolate the fields the frontend leaves alone.
+export function variableValues(templateSrv: TemplateSrv, scopedVars: ScopedVars): Record<string, string[]> {
+  const variables: Record<string, string[]> = {};
+  for (const variable of templateSrv.getVariables()) {
+    variables[variable.name] = expandVariableToArray(templateSrv, `$${variable.name}`, scopedVars);
+  }
+  return variables;
+}
+
 export function interpolateFilter(
   templateSrv: TemplateSrv,
   filter: GAFilter,
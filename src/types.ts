@@ -8,6 +8,10 @@ export interface GAQuery extends DataQuery {
   displayName: Map<string, string>
   accountId: string;
   webPropertyId: string;
+  webPropertyIds?: string[];
+  // 'sum' adds up the rows of all properties, for counts only: rates and
+  // averages such as bounceRate are rejected
+  propertyMerge?: '' | 'sum';
   profileId: string;
   startDate: string;
   endDate: string;
@@ -20,10 +24,36 @@ export interface GAQuery extends DataQuery {
   cacheDurationSeconds?: number;
   timezone: string;
   filtersExpression: string;
+  // raw queries carry a single statement, e.g. SELECT sessions BY date, country
+  editorMode?: '' | 'raw';
+  rawQuery?: string;
   mode: string;
   dimensionFilter: GAFilterExpression;
   metricFilter?: GAFilterExpression;
   serviceLevel: string;
+  streaming?: boolean;
+  streamInterval?: number;
+  minuteRanges?: GAMinuteRange[];
+  calculatedFields?: GACalculatedField[];
+  variables?: Record<string, string[]>;
+  // variable values when the query was last edited, for alerting, public
+  // dashboards and reporting, which run the query without the dashboard
+  variableDefaults?: Record<string, string[]>;
+  annotationType?: 'changeHistory' | 'events';
+  eventNames?: string[];
+}
+
+// https://developers.google.com/analytics/devguides/reporting/data/v1/rest/v1beta/MinuteRange
+export interface GAMinuteRange {
+  name?: string;
+  startMinutesAgo: number;
+  endMinutesAgo: number;
+}
+
+// evaluated per row by the backend, e.g. round(purchaseRevenue / activeUsers, 2)
+export interface GACalculatedField {
+  name: string;
+  expression: string;
 }
 
 // mapping on google-key.json
@@ -41,7 +71,20 @@ export const defaultQuery: Partial<GAQuery> = {};
  * Extends @grafana/google-sdk's DataSourceOptions so the shared
  * <ConnectionConfig /> component can read/write the same fields.
  */
-export interface GADataSourceOptions extends GoogleDataSourceOptions {}
+export interface GADataSourceOptions extends GoogleDataSourceOptions {
+  // Report cache duration for queries without cacheDurationSeconds
+  defaultCacheDurationSeconds?: number;
+  // On-disk cache that survives plugin restarts
+  persistentCache?: boolean;
+  persistentCacheDirectory?: string;
+  persistentCacheMaxSizeMb?: number;
+  // Restrictions enforced by the backend; empty lists allow every account/property
+  allowedAccounts?: string[];
+  allowedProperties?: string[];
+  enforcedDimensionFilter?: GAFilterExpression;
+  // Property the health check runs its test report against
+  healthCheckPropertyId?: string;
+}
 
 /**
  * Secret values stored on the backend. Extends the SDK's
@@ -68,6 +111,22 @@ export interface GAMetadataAttribute {
   description: string;
   allowedInSegments?: string;
   addedInAPIVersion?: string;
+  customDefinition?: boolean;
+  deprecatedApiNames?: string[];
+  expression?: string;
+  scope?: string;
+  parameterName?: string;
+}
+
+export interface GAFieldCompatibility {
+  id: string;
+  uiName: string;
+  compatibility: 'COMPATIBLE' | 'INCOMPATIBLE' | 'COMPATIBILITY_UNSPECIFIED';
+}
+
+export interface GACompatibility {
+  dimensions: GAFieldCompatibility[];
+  metrics: GAFieldCompatibility[];
 }
 
 export interface AccountSummary {